@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+)
+
+// credsExpiryWindow refreshes temporary credentials this long before they expire, so that
+// requests of long running uploads are not signed with credentials about to expire
+const credsExpiryWindow = 5 * time.Minute
+
+// watchCredentials reflects the AWS credential settings, a user or destination mode replaces
+// the defaults
+type watchCredentials struct {
+	Mode        string        `yaml:"mode"`        // empty (SDK default chain), profile, env, instance, web-identity or assume-role
+	Rolearn     string        `yaml:"rolearn"`     // web-identity and assume-role
+	Externalid  string        `yaml:"externalid"`  // assume-role
+	Sessionname string        `yaml:"sessionname"` // web-identity and assume-role, defaults to sftppush
+	Duration    time.Duration `yaml:"duration"`    // web-identity and assume-role, defaults to the STS default
+	Tokenfile   string        `yaml:"tokenfile"`   // web-identity, defaults to $AWS_WEB_IDENTITY_TOKEN_FILE
+}
+
+// orCredentials returns the user credential settings if a mode is set, the defaults otherwise
+func (w *watchConfigOps) orCredentials(u watchCredentials, d watchCredentials) watchCredentials {
+	if u.Mode != "" {
+		return u
+	}
+	return d
+}
+
+// sharesProfile reports whether the credential mode reads the shared profile, if any
+func (w *watchConfigOps) sharesProfile(c watchCredentials) bool {
+	switch c.Mode {
+	case "", "profile", "assume-role":
+		return true
+	}
+	return false
+}
+
+// awsCredentials returns the credentials of the mode. It returns nil if the credentials of the
+// session apply: the shared profile, or the SDK default chain of environment, shared config,
+// web identity and instance role. Temporary credentials are refreshed once they expire.
+func (w *watchConfigOps) awsCredentials(sess *session.Session, c watchCredentials, profile string) (*credentials.Credentials, error) {
+	name := c.Sessionname
+	if name == "" {
+		name = "sftppush"
+	}
+	switch c.Mode {
+	case "":
+		return nil, nil
+	case "profile":
+		if profile == "" {
+			return nil, errors.New("credentials mode profile requires awsprofile")
+		}
+		return nil, nil
+	case "env":
+		return credentials.NewEnvCredentials(), nil
+	case "instance":
+		return ec2rolecreds.NewCredentials(sess, func(p *ec2rolecreds.EC2RoleProvider) {
+			p.ExpiryWindow = credsExpiryWindow
+		}), nil
+	case "web-identity":
+		arn := c.Rolearn
+		if arn == "" {
+			arn = os.Getenv("AWS_ROLE_ARN")
+		}
+		token := c.Tokenfile
+		if token == "" {
+			token = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+		}
+		if arn == "" || token == "" {
+			return nil, errors.New("credentials mode web-identity requires rolearn and tokenfile")
+		}
+		p := stscreds.NewWebIdentityRoleProvider(sts.New(sess), arn, name, token)
+		p.Duration = c.Duration
+		p.ExpiryWindow = credsExpiryWindow
+		return credentials.NewCredentials(p), nil
+	case "assume-role":
+		if c.Rolearn == "" {
+			return nil, errors.New("credentials mode assume-role requires rolearn")
+		}
+		return stscreds.NewCredentials(sess, c.Rolearn, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = name
+			p.Duration = c.Duration
+			p.ExpiryWindow = credsExpiryWindow
+			if c.Externalid != "" {
+				p.ExternalID = &c.Externalid
+			}
+		}), nil
+	}
+	return nil, errors.Errorf("unknown credentials mode %q, want profile, env, instance, web-identity or assume-role", c.Mode)
+}
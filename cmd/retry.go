@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/olmax99/sftppush/pkg/event"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// cmdRetry represents the retry command
+var cmdRetry = &cobra.Command{
+	Use:   "retry",
+	Short: "Push all files from the dead-letter directories once more",
+	Long: strings.TrimSpace(`
+The retry command moves every file found in the users' dead-letter
+directories back to its original source directory and runs it through
+the upload pipeline again. Files failing again are dead-lettered anew.
+
+It can run alongside the watch command, as long as the dead-letter
+directories share the file system of the sources: restored files are
+renamed and do not trigger another WRITE_CLOSE event.
+
+Examples:
+
+sftppush --config config.yaml retry
+`),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := watchConfigOps{}
+
+		// Confirm that required parameters are set
+		if err := w.confirmConfig(&gCfg); err != nil {
+			return errors.Wrap(err, "required paramters missing")
+		}
+
+		epi, err := w.newPushInfo(&gCfg)
+		if err != nil {
+			return errors.Wrap(err, "newPushInfo")
+		}
+		e := event.FsEventOps{}
+		if err := e.Retry(epi, gL); err != nil {
+			return errors.Wrap(err, "Retry")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cmdRetry)
+}
@@ -3,19 +3,23 @@ package cmd
 import (
 	"errors"
 	log1 "log" // Use built-in log prior to logrus
+	"os"
 	"strings"
 
 	config "github.com/olmax99/sftppush/internal/config"
 	log "github.com/olmax99/sftppush/internal/log"
+	"github.com/olmax99/sftppush/pkg/event"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
 	cfgFile string
 	gCfg    watchConfig // global watchConfig accessed by watch.go and event/watcher.go
 	gL      *logrus.Logger
+	gV      *viper.Viper // config read on startup, watched for changes by watch.go
 	msg     string
 )
 
@@ -63,11 +67,12 @@ defaults:
   awsprofile: my-profile
   awsregion: my-region
 watch:
-  source:
+  users:
     - name: user1
-      paths:
-        - /path/to/source/directory1
-        - /path/to/source/directory2
+      recursive: true
+      sources:
+        - /device1/data
+        - /device2/data
 
 Examples:
 
@@ -94,6 +99,11 @@ SFTPPUSH_DEFAULTS_AWSPROFILE=my-profile sftppush watch \
 // needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
+		// exit status 2 signals uploads left unfinished on shutdown
+		if errors.Is(err, event.ErrAbandoned) {
+			gL.Errorf("%s", err)
+			os.Exit(2)
+		}
 		gL.Fatalf("%s", err)
 	}
 }
@@ -101,7 +111,8 @@ func Execute() {
 //!+ viper, config
 
 func initConfig() {
-	v := config.ReadConfig("SFTPPUSH", cfgFile)
+	v, _ := config.ReadConfig("SFTPPUSH", cfgFile) // missing config file is not fatal
+	gV = v
 	if err := v.Unmarshal(&gCfg); err != nil {
 		log1.Fatalf("%s", errors.New("unmarshal"))
 	}
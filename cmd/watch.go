@@ -2,9 +2,22 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
@@ -13,6 +26,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/fsnotify/fsnotify"
+	config "github.com/olmax99/sftppush/internal/config"
 	"github.com/olmax99/sftppush/pkg/event"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -21,35 +36,192 @@ import (
 // watchConfig reflects the yaml config file parameters
 type watchConfig struct {
 	Defaults struct {
-		Userpath   string `yaml:"userpath"`
-		S3Target   string `yaml:"s3target"`
-		Awsprofile string `yaml:"awsprofile"`
-		Awsregion  string `yaml:"awsregion"`
-		Log        struct {
+		Userpath     string             `yaml:"userpath"`
+		S3Target     string             `yaml:"s3target"`
+		Prefix       string             `yaml:"prefix"`
+		Awsprofile   string             `yaml:"awsprofile"`
+		Awsregion    string             `yaml:"awsregion"`
+		Endpoint     string             `yaml:"endpoint"`
+		Pathstyle    bool               `yaml:"pathstyle"`
+		Cabundle     string             `yaml:"cabundle"`
+		Insecure     bool               `yaml:"insecure"`
+		Credentials  watchCredentials   `yaml:"credentials"`
+		Destination  watchDestination   `yaml:"destination"`
+		Destinations []watchDestination `yaml:"destinations"`
+		Removal      string             `yaml:"removal"`
+		Workers      int                `yaml:"workers"`
+		Ordered      bool               `yaml:"ordered"`
+		Timeout      watchTimeout       `yaml:"timeout"`
+		Retry        struct {
+			Attempts   int           `yaml:"attempts"`
+			Backoff    time.Duration `yaml:"backoff"`
+			Maxbackoff time.Duration `yaml:"maxbackoff"`
+		} `yaml:"retry"`
+		Archive struct {
+			Keep       bool  `yaml:"keep"`
+			Skipdirs   bool  `yaml:"skipdirs"`
+			Maxsize    int64 `yaml:"maxsize"`
+			Maxentries int   `yaml:"maxentries"`
+		} `yaml:"archive"`
+		Integrity struct {
+			Verify   bool `yaml:"verify"`
+			Metadata bool `yaml:"metadata"`
+		} `yaml:"integrity"`
+		Deadletter string          `yaml:"deadletter"`
+		Types      watchTypes      `yaml:"types"`
+		Sidecar    watchSidecar    `yaml:"sidecar"`
+		Ready      watchReady      `yaml:"ready"`
+		Quarantine string          `yaml:"quarantine"`
+		Output     watchOutput     `yaml:"output"`
+		Encryption watchEncryption `yaml:"encryption"`
+		Storage    watchStorage    `yaml:"storage"`
+		Key        string          `yaml:"key"`
+		Queue      string          `yaml:"queue"`
+		Grace      time.Duration   `yaml:"grace"`
+		Settle     time.Duration   `yaml:"settle"`
+		Backfill   struct {
+			Skip   bool          `yaml:"skip"`
+			Minage time.Duration `yaml:"minage"`
+		} `yaml:"backfill"`
+		Log struct {
 			Format   string `yaml:"format"`
 			Location string `yaml:"location"`
 			Level    string `yaml:"level"`
 		} `yaml:"log"`
 	} `yaml:"defaults"`
 	Watch struct {
-		Users []struct {
-			Name    string   `yaml:"name"`
-			Sources []string `yaml:"sources"`
-		} `yaml:"users"`
+		Users []watchUser `yaml:"users"`
 	} `yaml:"watch"`
 }
 
+// watchUser reflects a single entry of the watch.users config section
+type watchUser struct {
+	Name         string             `yaml:"name"`
+	Sources      []string           `yaml:"sources"`
+	Recursive    bool               `yaml:"recursive"`
+	Timeout      watchTimeout       `yaml:"timeout"`
+	Deadletter   string             `yaml:"deadletter"`
+	Types        watchTypes         `yaml:"types"`
+	Sidecar      watchSidecar       `yaml:"sidecar"`
+	Ready        watchReady         `yaml:"ready"`
+	Quarantine   string             `yaml:"quarantine"`
+	Output       watchOutput        `yaml:"output"`
+	Encryption   watchEncryption    `yaml:"encryption"`
+	Storage      watchStorage       `yaml:"storage"`
+	Key          string             `yaml:"key"`
+	S3Target     string             `yaml:"s3target"`
+	Prefix       string             `yaml:"prefix"`
+	Awsprofile   string             `yaml:"awsprofile"`
+	Awsregion    string             `yaml:"awsregion"`
+	Endpoint     string             `yaml:"endpoint"`
+	Pathstyle    bool               `yaml:"pathstyle"`
+	Cabundle     string             `yaml:"cabundle"`
+	Insecure     bool               `yaml:"insecure"`
+	Credentials  watchCredentials   `yaml:"credentials"`
+	Destination  watchDestination   `yaml:"destination"`
+	Destinations []watchDestination `yaml:"destinations"`
+	Removal      string             `yaml:"removal"`
+}
+
+// watchDestination reflects the storage destination, a user type replaces the defaults.
+// The S3 settings default to those of the user, pathstyle and insecure are enabled if set
+// on any level.
+type watchDestination struct {
+	Type       string `yaml:"type"`      // s3 (default), minio or dir
+	Path       string `yaml:"path"`      // root directory of type dir
+	Accesskey  string `yaml:"accesskey"` // static credentials of type minio
+	Secretkey  string `yaml:"secretkey"`
+	S3Target   string `yaml:"s3target"`
+	Awsprofile string `yaml:"awsprofile"`
+	Awsregion  string `yaml:"awsregion"`
+	Endpoint   string `yaml:"endpoint"`  // e.g. https://rgw.example.local:7480
+	Pathstyle  bool   `yaml:"pathstyle"` // bucket in the path instead of the host name
+	Cabundle   string `yaml:"cabundle"`  // PEM file of additional trusted CAs
+	Insecure   bool   `yaml:"insecure"`  // skip TLS verification, labs only
+
+	Credentials watchCredentials `yaml:"credentials"`
+}
+
+// s3Settings are the resolved S3 connection settings of a destination
+type s3Settings struct {
+	Profile   string
+	Region    string
+	Endpoint  string
+	Bucket    string
+	PathStyle bool
+	CABundle  string
+	Insecure  bool
+
+	Credentials watchCredentials
+}
+
+// watchOutput reflects the object output settings, user settings override the defaults
+type watchOutput struct {
+	Compression string `yaml:"compression"`
+}
+
+// watchEncryption reflects the server-side encryption settings, a user setting replaces the
+// defaults as a whole
+type watchEncryption struct {
+	Sse             string `yaml:"sse"`             // AES256 or aws:kms
+	Kmskeyid        string `yaml:"kmskeyid"`        // aws:kms key ID, alias or ARN
+	Bucketkey       bool   `yaml:"bucketkey"`       // aws:kms S3 Bucket Key
+	Customerkeyfile string `yaml:"customerkeyfile"` // SSE-C key, 32 bytes raw or base64 encoded
+}
+
+// watchStorage reflects the S3 storage settings, user settings override the defaults, user tags
+// replace the default tags and metadata is added if enabled on either level
+type watchStorage struct {
+	Class    string            `yaml:"class"`    // e.g. STANDARD_IA, GLACIER_IR, DEEP_ARCHIVE, INTELLIGENT_TIERING
+	Acl      string            `yaml:"acl"`      // canned ACL
+	Tags     map[string]string `yaml:"tags"`     // values are templates of the key fields
+	Metadata bool              `yaml:"metadata"` // source mtime, size, mode, path and version
+}
+
+// watchSidecar reflects the checksum sidecar settings, a user wait overrides the default one
+// and the switches are enabled if set on either level
+type watchSidecar struct {
+	Verify  bool          `yaml:"verify"`
+	Wait    time.Duration `yaml:"wait"`
+	Require bool          `yaml:"require"`
+	Upload  bool          `yaml:"upload"`
+}
+
+// watchReady reflects the readiness markers, user markers replace the defaults
+type watchReady struct {
+	Markers []string `yaml:"markers"` // e.g. "{name}.done" for a single file, "_SUCCESS" for its directory
+}
+
+// watchTypes reflects the MIME type allow and deny lists, user lists replace the defaults
+type watchTypes struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// watchTimeout reflects the upload timeout settings, user settings override the defaults
+type watchTimeout struct {
+	Base  time.Duration `yaml:"base"`
+	Permb time.Duration `yaml:"permb"`
+	Idle  time.Duration `yaml:"idle"`
+}
+
 // watchConfigOperations contains all methods needed to process input to cmdWatch
 // type watchConfigOperations interface {
-// 	createWatcher(eops event.FsEventOps, globalCfg *watchConfig) error
+// 	createWatcher(ctx context.Context, eops event.FsEventOps, globalCfg *watchConfig) error
 // 	checkDir(path string) (bool, error)
 // 	unmarshalWatchFlag(flagIn []string, globalCfg *watchConfig) error
-// 	newS3Conn(profile *string, region *string) *s3.S3
+// 	newS3Conn(s s3Settings) (*s3.S3, error)
 // }
 
 // watchConfigOps implements the watchConfigOperations interface
 type watchConfigOps struct{}
 
+// s3Conns caches one S3 client per distinct connection setting, reloads reuse them
+var s3Conns = struct {
+	sync.Mutex
+	m map[string]*s3.S3
+}{m: make(map[string]*s3.S3)}
+
 var src []string // watch flag --source read as string
 
 // cmdWatch represents the watch command
@@ -69,7 +241,7 @@ SFTPPUSH_DEFAULTS_USERPATH=/my/user/dir/ sftppush --config config.yaml watch
 
 SFTPPUSH_DEFAULTS_AWSPROFILE=my-profile sftppush watch \
   --source="name=user1,paths=/device1/data /device2/data" \
-  --source="name=user2,paths=/device1/data /device2/data"
+  --source="name=user2,paths=/device1/data /device2/data,recursive=true"
 `),
 	// Args: func(cmd *cobra.Command, args []string) error {
 	// 	if len(args) < 1 {
@@ -86,7 +258,7 @@ SFTPPUSH_DEFAULTS_AWSPROFILE=my-profile sftppush watch \
 			return errors.New("Use either '--source' flag or '--config'.")
 		}
 
-		gL.Debugf("cfgWatch (from config): %q", &gCfg)
+		gL.Debugf("cfgWatch (from config): %+v", gCfg)
 		gL.Debugf("cmdWatch (from flag): %s", src)
 
 		// Will overwrite config values if both --config and --sources are se
@@ -102,9 +274,45 @@ SFTPPUSH_DEFAULTS_AWSPROFILE=my-profile sftppush watch \
 			return errors.Wrap(err, "required paramters missing")
 		}
 
+		// SIGINT and SIGTERM stop the watcher gracefully, a second one exits immediately.
+		// SIGHUP and changes to the config file reload the watch.users section.
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		hup := make(chan struct{}, 1)
+		reload := func() {
+			select {
+			case hup <- struct{}{}:
+			default: // reload already pending
+			}
+		}
+		if gV.ConfigFileUsed() != "" {
+			gV.OnConfigChange(func(e fsnotify.Event) {
+				gL.Infof("Config file changed, %s", e)
+				reload()
+			})
+			gV.WatchConfig()
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		defer signal.Stop(sigs)
+		go func() {
+			for s := range sigs {
+				switch s {
+				case syscall.SIGHUP:
+					gL.Infof("Received %s, reloading config", s)
+					reload()
+				default:
+					gL.Infof("Received %s, shutting down", s)
+					signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+					cancel()
+				}
+			}
+		}()
+
 		// TODO Catch errors, implement a notification service
+		cmd.SilenceUsage = true
 		e := event.FsEventOps{}
-		if err := w.createWatcher(e, &gCfg); err != nil {
+		if err := w.createWatcher(ctx, e, &gCfg, hup); err != nil {
 			return errors.Wrap(err, "createWatcher")
 		}
 
@@ -121,55 +329,402 @@ func init() {
 
 // newWatcher encapsulates the fsnotify *NewWatcher creation and provides all data
 // needed for processing the events triggered by the new
-func (w *watchConfigOps) createWatcher(e event.FsEventOps, g *watchConfig) error {
-	id := g.Defaults.Awsprofile
-	reg := g.Defaults.Awsregion
-	c := w.newS3Conn(&id, &reg)
+func (w *watchConfigOps) createWatcher(ctx context.Context, e event.FsEventOps, g *watchConfig, hup <-chan struct{}) error {
+	epi, err := w.newPushInfo(g)
+	if err != nil {
+		return err
+	}
+	if epi.Queue, err = event.OpenJournal(g.Defaults.Queue); err != nil {
+		return err
+	}
+	defer epi.Queue.Close()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := w.reload(&e, epi); err != nil {
+					gL.Errorf("Reload rejected, keeping the running config: %s", err)
+				}
+			}
+		}
+	}()
+	return e.NewWatcher(ctx, epi, gL)
+}
 
+// reload re-reads the config and applies its watch.users section to the running watcher,
+// changes to the defaults section require a restart
+func (w *watchConfigOps) reload(e *event.FsEventOps, epi *event.EventPushInfo) error {
+	v, err := config.ReadConfig("SFTPPUSH", cfgFile)
+	if err != nil {
+		return errors.Wrap(err, "ReadConfig")
+	}
+	var next watchConfig
+	if err := v.Unmarshal(&next); err != nil {
+		return errors.Wrap(err, "unmarshal")
+	}
+	// --source flags keep overwriting the config file
+	if len(src) > 0 {
+		if err := w.unmarshalWatchFlag(src, &next); err != nil {
+			return errors.Wrapf(err, "decodeWatchFlag: %q", src)
+		}
+	}
+	if err := w.confirmConfig(&next); err != nil {
+		return err
+	}
+	if next.Defaults.Userpath != gCfg.Defaults.Userpath {
+		return errors.Errorf("defaults.userpath changed to %s, restart required", next.Defaults.Userpath)
+	}
+	dirs, users, err := w.newUsers(&next)
+	if err != nil {
+		return err
+	}
+	if err := e.Reload(epi, &event.EventPushInfo{Watchdirs: dirs, Users: users}, gL); err != nil {
+		return err
+	}
+	gCfg = next
+	return nil
+}
+
+// newPushInfo derives the EventPushInfo shared by all pipeline stages from the config
+func (w *watchConfigOps) newPushInfo(g *watchConfig) (*event.EventPushInfo, error) {
+	CheckedSrcDirs, users, err := w.newUsers(g)
+	if err != nil {
+		return nil, err
+	}
+
+	srcD := &g.Defaults.Userpath
+
+	epi := &event.EventPushInfo{
+		Userpath:  srcD,
+		Watchdirs: CheckedSrcDirs,
+		Users:     users,
+		Backfill:  !g.Defaults.Backfill.Skip,
+		MinAge:    g.Defaults.Backfill.Minage,
+		Settle:    g.Defaults.Settle,
+		Workers:   g.Defaults.Workers,
+		Ordered:   g.Defaults.Ordered,
+		Grace:     g.Defaults.Grace,
+		Archive: event.Archive{
+			Keep:       g.Defaults.Archive.Keep,
+			SkipDirs:   g.Defaults.Archive.Skipdirs,
+			MaxSize:    g.Defaults.Archive.Maxsize,
+			MaxEntries: g.Defaults.Archive.Maxentries,
+		},
+		Integrity: event.Integrity{
+			Verify:   g.Defaults.Integrity.Verify,
+			Metadata: g.Defaults.Integrity.Metadata,
+		},
+		Retry: event.Retry{
+			Attempts:   g.Defaults.Retry.Attempts,
+			Backoff:    g.Defaults.Retry.Backoff,
+			MaxBackoff: g.Defaults.Retry.Maxbackoff,
+		},
+		Results: make(chan *event.ResultInfo), // Consumer Stage-4
+	}
+	return epi, nil
+}
+
+// newUsers checks the source directories of all users and derives their settings from the config
+func (w *watchConfigOps) newUsers(g *watchConfig) ([]string, map[string]*event.UserInfo, error) {
 	srcD := &g.Defaults.Userpath
-	trgB := &g.Defaults.S3Target
 	arrU := &g.Watch.Users
 
 	CheckedSrcDirs := make([]string, 0) // : value
+	users := make(map[string]*event.UserInfo)
 	for _, u := range *arrU {
 		targetD := *srcD + u.Name // <defaults.userpath> + <watch.source.name>
+		ui := &event.UserInfo{
+			Name:       u.Name,
+			Recursive:  u.Recursive,
+			Timeout:    w.timeout(g.Defaults.Timeout, u.Timeout),
+			DeadLetter: filepath.Join(g.Defaults.Deadletter, u.Name), // <defaults.deadletter>/<watch.source.name>
+			Types:      w.types(g.Defaults.Types, u.Types),
+			Sidecar:    w.sidecar(g.Defaults.Sidecar, u.Sidecar),
+			Ready:      event.Ready{Markers: g.Defaults.Ready.Markers},
+			Quarantine: filepath.Join(g.Defaults.Quarantine, u.Name), // <defaults.quarantine>/<watch.source.name>
+			Output:     w.output(g.Defaults.Output, u.Output),
+		}
+		if key := w.orDefault(u.Key, g.Defaults.Key); key != "" {
+			t, err := event.ParseKeyTemplate(key)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "key of user %s", u.Name)
+			}
+			ui.Key = t
+		}
+		target, err := w.target(g, u)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "destination of user %s", u.Name)
+		}
+		ui.Target = target
+		if len(u.Ready.Markers) > 0 {
+			ui.Ready.Markers = u.Ready.Markers
+		}
+		if err := ui.Ready.Validate(); err != nil {
+			return nil, nil, errors.Wrapf(err, "ready of user %s", u.Name)
+		}
+		enc, err := w.encryption(g.Defaults.Encryption, u.Encryption)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "encryption of user %s", u.Name)
+		}
+		ui.Encryption = enc
+		st, err := w.storage(g.Defaults.Storage, u.Storage)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "storage of user %s", u.Name)
+		}
+		ui.Storage = st
+		switch ui.Output.Compression {
+		case "", "none", "gzip", "zstd":
+		default:
+			return nil, nil, errors.Errorf("output.compression %q of user %s, want none, gzip or zstd", ui.Output.Compression, u.Name)
+		}
+		if u.Deadletter != "" {
+			ui.DeadLetter = filepath.Clean(u.Deadletter)
+		}
+		if u.Quarantine != "" {
+			ui.Quarantine = filepath.Clean(u.Quarantine)
+		}
 		for _, srcP := range u.Sources {
 			tDir := targetD + srcP
 			d, err := w.checkDir(tDir)
-			if err != nil || !d {
-				return errors.Wrapf(err, "e.NewWatcher: targetDir %s does not exist.", tDir)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "e.NewWatcher: targetDir %s does not exist.", tDir)
+			}
+			if !d {
+				return nil, nil, errors.Errorf("e.NewWatcher: targetDir %s is not a directory.", tDir)
 			}
 			CheckedSrcDirs = append(CheckedSrcDirs, tDir)
+			users[filepath.Clean(tDir)] = ui
 		}
 	}
 
-	epi := &event.EventPushInfo{
-		Session:   c,
-		Userpath:  srcD,
-		Watchdirs: CheckedSrcDirs,
-		Bucket:    trgB,
-		Key:       "",
-		Results:   make(chan *event.ResultInfo), // Consumer Stage-4
+	// dead-lettered or quarantined files inside a watched directory would be picked up again
+	for d := range users {
+		for _, ui := range users {
+			if ui.DeadLetter == d || strings.HasPrefix(ui.DeadLetter, d+"/") {
+				return nil, nil, errors.Errorf("deadletter %s of user %s is inside source %s", ui.DeadLetter, ui.Name, d)
+			}
+			if ui.Quarantine == d || strings.HasPrefix(ui.Quarantine, d+"/") {
+				return nil, nil, errors.Errorf("quarantine %s of user %s is inside source %s", ui.Quarantine, ui.Name, d)
+			}
+		}
+	}
+
+	return CheckedSrcDirs, users, nil
+}
+
+// timeout merges the user timeout settings into the defaults
+func (w *watchConfigOps) timeout(d watchTimeout, u watchTimeout) event.Timeout {
+	if u.Base != 0 {
+		d.Base = u.Base
+	}
+	if u.Permb != 0 {
+		d.Permb = u.Permb
+	}
+	if u.Idle != 0 {
+		d.Idle = u.Idle
+	}
+	return event.Timeout{
+		Base:  d.Base,
+		PerMB: d.Permb,
+		Idle:  d.Idle,
+	}
+}
+
+// sidecar merges the user checksum sidecar settings into the defaults
+func (w *watchConfigOps) sidecar(d watchSidecar, u watchSidecar) event.Sidecar {
+	if u.Wait != 0 {
+		d.Wait = u.Wait
+	}
+	return event.Sidecar{
+		Verify:  d.Verify || u.Verify,
+		Wait:    d.Wait,
+		Require: d.Require || u.Require,
+		Upload:  d.Upload || u.Upload,
 	}
-	e.NewWatcher(epi, gL)
-	return nil
+}
+
+// types returns the user MIME type lists, falling back to the defaults for each empty list
+func (w *watchConfigOps) types(d watchTypes, u watchTypes) event.Types {
+	if len(u.Allow) > 0 {
+		d.Allow = u.Allow
+	}
+	if len(u.Deny) > 0 {
+		d.Deny = u.Deny
+	}
+	return event.Types{Allow: d.Allow, Deny: d.Deny}
+}
+
+// target creates the destinations of the user, a list of destinations replaces the single one
+func (w *watchConfigOps) target(g *watchConfig, u watchUser) (event.Target, error) {
+	t := event.Target{Prefix: w.orDefault(u.Prefix, g.Defaults.Prefix)}
+	switch w.orDefault(u.Removal, g.Defaults.Removal) {
+	case "", "all":
+		t.Removal = event.RemoveAll
+	case "any":
+		t.Removal = event.RemoveAny
+	case "quorum":
+		t.Removal = event.RemoveQuorum
+	default:
+		return t, errors.Errorf("removal %q, want all, any or quorum", w.orDefault(u.Removal, g.Defaults.Removal))
+	}
+
+	for i, d := range w.destinations(g, u) {
+		dst, err := w.destination(d, w.s3Settings(g, u, d))
+		if err != nil {
+			return t, errors.Wrapf(err, "destination %d", i)
+		}
+		t.Destinations = append(t.Destinations, dst)
+	}
+	return t, nil
+}
+
+// destinations returns the destination settings of the user: its own list, the default list,
+// or the single user or default destination
+func (w *watchConfigOps) destinations(g *watchConfig, u watchUser) []watchDestination {
+	ds := u.Destinations
+	if len(ds) == 0 {
+		ds = g.Defaults.Destinations
+	}
+	if len(ds) == 0 {
+		d := g.Defaults.Destination
+		if u.Destination.Type != "" {
+			d = u.Destination
+		}
+		ds = []watchDestination{d}
+	}
+	return ds
+}
+
+// s3Settings resolves the S3 settings of a user destination, falling back to the user and
+// then to the defaults
+func (w *watchConfigOps) s3Settings(g *watchConfig, u watchUser, d watchDestination) s3Settings {
+	return s3Settings{
+		Profile:   w.orDefault(d.Awsprofile, w.orDefault(u.Awsprofile, g.Defaults.Awsprofile)),
+		Region:    w.orDefault(d.Awsregion, w.orDefault(u.Awsregion, g.Defaults.Awsregion)),
+		Endpoint:  w.orDefault(d.Endpoint, w.orDefault(u.Endpoint, g.Defaults.Endpoint)),
+		Bucket:    w.orDefault(d.S3Target, w.orDefault(u.S3Target, g.Defaults.S3Target)),
+		PathStyle: d.Pathstyle || u.Pathstyle || g.Defaults.Pathstyle,
+		CABundle:  w.orDefault(d.Cabundle, w.orDefault(u.Cabundle, g.Defaults.Cabundle)),
+		Insecure:  d.Insecure || u.Insecure || g.Defaults.Insecure,
+
+		Credentials: w.orCredentials(d.Credentials, w.orCredentials(u.Credentials, g.Defaults.Credentials)),
+	}
+}
+
+// destination creates the storage destination of the config, S3 clients are shared
+func (w *watchConfigOps) destination(d watchDestination, s s3Settings) (event.Destination, error) {
+	switch d.Type {
+	case "", "s3":
+		c, err := w.s3Conn(s)
+		if err != nil {
+			return nil, err
+		}
+		return event.NewS3Destination(c, s.Bucket), nil
+	case "minio":
+		if s.Endpoint == "" || s.Bucket == "" {
+			return nil, errors.New("minio requires endpoint and s3target")
+		}
+		hc, err := w.httpClient(s.CABundle, s.Insecure)
+		if err != nil {
+			return nil, err
+		}
+		return event.NewMinIODestination(s.Endpoint, s.Region, d.Accesskey, d.Secretkey, s.Bucket, &aws.Config{HTTPClient: hc})
+	case "dir":
+		if !filepath.IsAbs(d.Path) {
+			return nil, errors.Errorf("dir requires an absolute path, got %q", d.Path)
+		}
+		return event.NewDirDestination(d.Path), nil
+	}
+	return nil, errors.Errorf("unknown type %q, want s3, minio or dir", d.Type)
+}
+
+// orDefault returns the user setting if set, the default otherwise
+func (w *watchConfigOps) orDefault(u string, d string) string {
+	if u != "" {
+		return u
+	}
+	return d
+}
+
+// encryption returns the user encryption settings if any, the defaults otherwise, the SSE-C key
+// is read from its file
+func (w *watchConfigOps) encryption(d watchEncryption, u watchEncryption) (event.Encryption, error) {
+	if u != (watchEncryption{}) {
+		d = u
+	}
+	e := event.Encryption{SSE: d.Sse, KMSKeyID: d.Kmskeyid, BucketKey: d.Bucketkey}
+	if d.Customerkeyfile != "" {
+		b, err := ioutil.ReadFile(d.Customerkeyfile)
+		if err != nil {
+			return e, errors.Wrap(err, "customerkeyfile")
+		}
+		if k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b))); err == nil && len(k) == 32 {
+			b = k
+		}
+		e.CustomerKey = b
+	}
+	return e, e.Validate()
+}
+
+// storage merges the user storage settings into the defaults and parses the tag templates
+func (w *watchConfigOps) storage(d watchStorage, u watchStorage) (event.Storage, error) {
+	if len(u.Tags) > 0 {
+		d.Tags = u.Tags
+	}
+	tags, err := event.ParseTagTemplates(d.Tags)
+	if err != nil {
+		return event.Storage{}, err
+	}
+	st := event.Storage{
+		Class:    w.orDefault(u.Class, d.Class),
+		ACL:      w.orDefault(u.Acl, d.Acl),
+		Tags:     tags,
+		Metadata: d.Metadata || u.Metadata,
+	}
+	return st, st.Validate()
+}
+
+// output merges the user output settings into the defaults
+func (w *watchConfigOps) output(d watchOutput, u watchOutput) event.Output {
+	if u.Compression != "" {
+		d.Compression = u.Compression
+	}
+	return event.Output{Compression: d.Compression}
 }
 
 func (w *watchConfigOps) confirmConfig(g *watchConfig) error {
-	// Confirm that Aws parameters are present
-	switch v := g.Defaults; {
-	case v.Awsprofile == "":
-		return errors.Wrap(errors.New("Awsprofile not set"), "confirmConfig failed")
-	case v.Awsregion == "":
-		return errors.Wrap(errors.New("Awsregion not set"), "confirmConfig failed")
-	case v.S3Target == "":
-		return errors.Wrap(errors.New("S3Target not set"), "confirmConfig failed")
+	// Confirm that Aws parameters are present for S3 destinations, others are checked along
+	// with the destination
+	for _, u := range g.Watch.Users {
+		for _, d := range w.destinations(g, u) {
+			if d.Type != "" && d.Type != "s3" {
+				continue
+			}
+			switch s := w.s3Settings(g, u, d); {
+			case s.Region == "":
+				return errors.Wrapf(errors.New("Awsregion not set"), "confirmConfig failed, user %s", u.Name)
+			case s.Bucket == "":
+				return errors.Wrapf(errors.New("S3Target not set"), "confirmConfig failed, user %s", u.Name)
+			}
+		}
 	}
 
-	// log final config
+	// log final config, without secrets
+	c := *g
+	c.Defaults.Destination.Secretkey = mask(c.Defaults.Destination.Secretkey)
+	c.Defaults.Destinations = maskAll(c.Defaults.Destinations)
+	c.Watch.Users = make([]watchUser, len(g.Watch.Users))
+	for i, u := range g.Watch.Users {
+		u.Destination.Secretkey = mask(u.Destination.Secretkey)
+		u.Destinations = maskAll(u.Destinations)
+		c.Watch.Users[i] = u
+	}
 	var body interface{}
 	reqBodyBytes := new(bytes.Buffer)
-	if err := json.NewEncoder(reqBodyBytes).Encode(g); err != nil {
+	if err := json.NewEncoder(reqBodyBytes).Encode(&c); err != nil {
 		return errors.Wrap(err, "confirmConfig")
 	}
 
@@ -183,26 +738,40 @@ func (w *watchConfigOps) confirmConfig(g *watchConfig) error {
 	return nil
 }
 
+// mask hides a secret config value in the log
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "***"
+}
+
+// maskAll returns a copy of the destinations with their secrets hidden
+func maskAll(ds []watchDestination) []watchDestination {
+	res := make([]watchDestination, len(ds))
+	for i, d := range ds {
+		d.Secretkey = mask(d.Secretkey)
+		res[i] = d
+	}
+	return res
+}
+
 // unmarshalWatchFlag will store the flag input into the global config instance and
 // thereby overwriting the data received from the config file
 func (w *watchConfigOps) unmarshalWatchFlag(flagIn []string, g *watchConfig) error {
-	g.Watch = struct {
-		Users []struct {
-			Name    string   `yaml:"name"`
-			Sources []string `yaml:"sources"`
-		} "yaml:\"users\""
-	}{} // reset values set by config file
+	g.Watch.Users = nil // reset values set by config file
 
 	type results struct {
-		name  string
-		paths []string
+		name      string
+		paths     []string
+		recursive bool
 	}
 
 	for _, entries := range flagIn {
 		r := results{}
 		entries := strings.Split(entries, ",")
 		// verify entry format
-		if len(entries) != 2 {
+		if len(entries) < 2 {
 			return errors.New("Ensure name, and paths are set. Run 'sftppush help'.")
 		}
 		for _, p := range entries {
@@ -214,61 +783,119 @@ func (w *watchConfigOps) unmarshalWatchFlag(flagIn []string, g *watchConfig) err
 				r.name = v
 			case "paths":
 				r.paths = strings.Fields(v)
+			case "recursive":
+				b, err := strconv.ParseBool(v)
+				if err != nil {
+					return errors.Wrapf(err, "Invalid entry: %s", p)
+				}
+				r.recursive = b
 			default:
 				return errors.Errorf("Unknown entry: %s", p)
 			}
 		}
 
-		g.Watch.Users = append(g.Watch.Users, struct {
-			Name    string   `yaml:"name"`
-			Sources []string `yaml:"sources"`
-		}{
-			Name:    r.name,
-			Sources: r.paths,
+		g.Watch.Users = append(g.Watch.Users, watchUser{
+			Name:      r.name,
+			Sources:   r.paths,
+			Recursive: r.recursive,
 		})
 
 	}
 	return nil
 }
 
-// newS3Conn creates a new AWS Api session
-func (w *watchConfigOps) newS3Conn(p *string, r *string) *s3.S3 {
-	// TODO Use EC2 Instance Role
+// s3Conn returns the cached S3 client of the connection settings, creating it on first use
+func (w *watchConfigOps) s3Conn(s s3Settings) (*s3.S3, error) {
+	s3Conns.Lock()
+	defer s3Conns.Unlock()
+	s.Bucket = "" // clients are not bound to a bucket
+	k := fmt.Sprintf("%+v", s)
+	if c, ok := s3Conns.m[k]; ok {
+		return c, nil
+	}
+	c, err := w.newS3Conn(s)
+	if err != nil {
+		return nil, err
+	}
+	s3Conns.m[k] = c
+	return c, nil
+}
+
+// newS3Conn creates a new AWS Api session, an endpoint points it at S3-compatible storage
+// like MinIO or Ceph RGW
+func (w *watchConfigOps) newS3Conn(s s3Settings) (*s3.S3, error) {
+	hc, err := w.httpClient(s.CABundle, s.Insecure)
+	if err != nil {
+		return nil, err
+	}
 
 	// ####
 	// # Secure Credentials
 	// ####
 
-	// Initial credentials loaded from SDK's default credential chain. Such as
-	// the environment, shared credentials (~/.aws/credentials), or EC2 Instance
-	// Role. These credentials will be used to to make the STS Assume Role API.
-	// sess := session.Must(session.NewSession())
-
-	// Create the credentials from AssumeRoleProvider to assume the role
-	// referenced by the "myRoleARN" ARN.
-	//creds := stscreds.NewCredentials(sess, "myRoleArn")
-
-	// Create service client value configured for credentials
-	// from assumed role.
-	//svc := s3.New(sess, &aws.Config{Credentials: creds})/
-
-	profile := *p
-	region := *r
-	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewSharedCredentials("", profile),
-	})
+	// The session holds the base credentials, the shared profile if set or else the SDK's
+	// default chain. STS clients of the web-identity and assume-role modes are created from it,
+	// the endpoint settings thus only apply to the S3 client.
+	base := &aws.Config{
+		Region:     aws.String(s.Region),
+		HTTPClient: hc,
+	}
+	if s.Profile != "" && w.sharesProfile(s.Credentials) {
+		base.Credentials = credentials.NewSharedCredentials("", s.Profile)
+	}
+	sess, err := session.NewSession(base)
 	if err != nil {
-		log.Fatalf("FATAL[-] cmdWatch, NewSession: %s\n", err)
+		return nil, errors.Wrap(err, "NewSession")
 	}
-	_, err = sess.Config.Credentials.Get()
+	creds, err := w.awsCredentials(sess, s.Credentials, s.Profile)
 	if err != nil {
-		log.Printf("WARNING[-] cmdWatch, Credentials: %s\n", err)
+		return nil, err
 	}
 
-	svcS3 := s3.New(sess)
+	cfg := &aws.Config{
+		Credentials:      creds,
+		S3ForcePathStyle: aws.Bool(s.PathStyle),
+	}
+	if s.Endpoint != "" {
+		cfg.Endpoint = aws.String(s.Endpoint)
+		cfg.DisableSSL = aws.Bool(strings.HasPrefix(s.Endpoint, "http://"))
+	}
+	svcS3 := s3.New(sess, cfg)
+	_, err = svcS3.Config.Credentials.Get()
+	if err != nil {
+		log.Printf("WARNING[-] cmdWatch, Credentials: %s\n", err)
+	}
 	log.Printf("INFO[+] NewSess: %s\n", svcS3.ClientInfo.Endpoint)
-	return svcS3
+	return svcS3, nil
+}
+
+// httpClient returns the HTTP client of the S3 API, trusting the CA bundle in addition to the
+// system roots. Insecure disables the certificate verification and is meant for labs only.
+func (w *watchConfigOps) httpClient(caBundle string, insecure bool) (*http.Client, error) {
+	if caBundle == "" && !insecure {
+		return http.DefaultClient, nil
+	}
+	tc := &tls.Config{InsecureSkipVerify: insecure}
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			return nil, errors.Wrap(err, "cabundle")
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("cabundle %s: no PEM certificates", caBundle)
+		}
+		tc.RootCAs = pool
+	}
+	if insecure {
+		log.Printf("WARNING[-] cmdWatch, TLS verification disabled\n")
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tc
+	return &http.Client{Transport: t}, nil
 }
 
 // checkDir ensures that the source watch directories exist
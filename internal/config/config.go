@@ -8,7 +8,9 @@ import (
 	"github.com/spf13/viper"
 )
 
-func ReadConfig(appName string, cfgFile string) *viper.Viper {
+// ReadConfig returns the viper config of defaults, config file and ENV variables. The error
+// is set if the config file could not be read, the returned config is usable nonetheless.
+func ReadConfig(appName string, cfgFile string) (*viper.Viper, error) {
 	v := viper.New()
 	// Defines Prefix for ENV variables and parses them by default
 	v.SetEnvPrefix(appName)
@@ -19,6 +21,16 @@ func ReadConfig(appName string, cfgFile string) *viper.Viper {
 	// global defaults (key value) - need trailing '/'
 	v.SetDefault("defaults.userpath", "/home/")
 	v.SetDefault("defaults.log.level", "debug")
+	v.SetDefault("defaults.backfill.minage", "1m")
+	v.SetDefault("defaults.workers", 4)
+	v.SetDefault("defaults.grace", "30s")
+	v.SetDefault("defaults.timeout.base", "1m")
+	v.SetDefault("defaults.timeout.permb", "2s")
+	v.SetDefault("defaults.timeout.idle", "30s")
+	v.SetDefault("defaults.output.compression", "none")
+	v.SetDefault("defaults.archive.skipdirs", true)
+	v.SetDefault("defaults.archive.maxsize", 4<<30)
+	v.SetDefault("defaults.archive.maxentries", 10000)
 
 	// Find home directory.
 	home, err := os.UserHomeDir()
@@ -26,6 +38,12 @@ func ReadConfig(appName string, cfgFile string) *viper.Viper {
 		log1.Fatalf("ERROR[-] %s", err)
 	}
 	v.SetDefault("defaults.log.location", strings.Join([]string{home, ".sftppush", "sftppush.log"}, "/"))
+	v.SetDefault("defaults.deadletter", strings.Join([]string{home, ".sftppush", "deadletter"}, "/"))
+	v.SetDefault("defaults.quarantine", strings.Join([]string{home, ".sftppush", "quarantine"}, "/"))
+	v.SetDefault("defaults.queue", strings.Join([]string{home, ".sftppush", "queue.journal"}, "/"))
+	v.SetDefault("defaults.retry.attempts", 5)
+	v.SetDefault("defaults.retry.backoff", "2s")
+	v.SetDefault("defaults.retry.maxbackoff", "5m")
 
 	if cfgFile != "" {
 		// Use config file from the flag.
@@ -37,7 +55,8 @@ func ReadConfig(appName string, cfgFile string) *viper.Viper {
 	}
 
 	// Read config file and not found action
-	if err := v.ReadInConfig(); err == nil {
+	err = v.ReadInConfig()
+	if err == nil {
 		log1.Printf("INFO[+] Using config file: %s\n", v.ConfigFileUsed())
 
 	} else {
@@ -47,7 +66,7 @@ func ReadConfig(appName string, cfgFile string) *viper.Viper {
 	// Use if existing config exists
 	// v.MergeConfigMap(cfg map[string]interface{})
 
-	return v
+	return v, err
 }
 
 // TODO config file Validation
@@ -0,0 +1,208 @@
+package event
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// tarSniffLen is the size of a tar header block, its "ustar" magic sits at offset 257
+const tarSniffLen = 512
+
+//!+stage-2
+
+// unzip uploads every entry of a zip archive as a separate object below the archive key
+// without its extension, it stops at the first failed upload
+func (o *FsEventOps) unzip(done <-chan struct{}, f *os.File, j pushJob, pi *EventPushInfo, lg *logrus.Logger) *ResultInfo {
+	ctxLog := lg.WithField("stage", 2)
+	fail := func(err error) *ResultInfo {
+		return &ResultInfo{eventInfo: j.event, status: Failed, err: err}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return fail(errors.Wrapf(err, "unzip %s", j.event.Event.AbsLoc))
+	}
+	zr, err := zip.NewReader(f, fi.Size())
+	if err != nil {
+		return fail(errors.Wrapf(err, "unzip %s", j.event.Event.AbsLoc))
+	}
+	entries, err := archiveEntries(zr.File, pi.Archive)
+	if err != nil {
+		return fail(errors.Wrapf(err, "unzip %s", j.event.Event.AbsLoc))
+	}
+
+	prefix := strings.TrimSuffix(j.key, path.Ext(j.key)) + "/"
+	res := &ResultInfo{eventInfo: j.event, status: Uploaded}
+	for _, zf := range entries {
+		ej := j
+		name, _ := entryName(zf.Name) // validated by archiveEntries
+		ej.key = prefix + name
+		ej.metadata = entryMetadata(zf.Modified)
+		var rc io.ReadCloser = ioutil.NopCloser(bytes.NewReader(nil))
+		if zf.FileInfo().IsDir() {
+			ej.key += "/" // empty marker object
+			ej.raw = true
+		} else if rc, err = zf.Open(); err != nil {
+			return fail(errors.Wrapf(err, "unzip %s: %s", j.event.Event.AbsLoc, zf.Name))
+		}
+		ej.body = rc
+		ctxLog.Debugf("unzip %s: %s", j.event.Event.AbsLoc, ej.key)
+		r := o.push(done, ej, pi, lg)
+		rc.Close()
+		res.collect(r)
+		if r.status != Uploaded {
+			r.keys, r.destinations = res.keys, res.destinations
+			return r
+		}
+		res.response = r.response
+		res.keys = append(res.keys, ej.key)
+	}
+	return o.keepArchive(done, f, j, res, pi, lg)
+}
+
+// untar uploads every regular file of a tar stream as a separate object below the archive key
+// without its extension. The stream is read in a single pass, the entry limits thus fail the
+// event once they are exceeded, with the earlier entries already uploaded.
+func (o *FsEventOps) untar(done <-chan struct{}, f *os.File, r io.Reader, j pushJob, pi *EventPushInfo, lg *logrus.Logger) *ResultInfo {
+	ctxLog := lg.WithField("stage", 2)
+	res := &ResultInfo{eventInfo: j.event, status: Uploaded}
+	fail := func(err error) *ResultInfo {
+		return &ResultInfo{eventInfo: j.event, keys: res.keys, status: Failed, err: errors.Wrapf(err, "untar %s", j.event.Event.AbsLoc)}
+	}
+
+	prefix := strings.TrimSuffix(j.key, path.Ext(j.key)) + "/"
+	tr := tar.NewReader(r)
+	var total int64
+	for n := 0; ; {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		if h.Typeflag != tar.TypeReg && h.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name, err := entryName(h.Name)
+		if err != nil {
+			return fail(err)
+		}
+		n++
+		if pi.Archive.MaxEntries > 0 && n > pi.Archive.MaxEntries {
+			return fail(errors.Errorf("more than %d entries", pi.Archive.MaxEntries))
+		}
+		total += h.Size
+		if pi.Archive.MaxSize > 0 && total > pi.Archive.MaxSize {
+			return fail(errors.Errorf("uncompressed size exceeds %d bytes", pi.Archive.MaxSize))
+		}
+
+		ej := j
+		ej.key = prefix + name
+		ej.body = tr
+		ej.metadata = entryMetadata(h.ModTime)
+		ctxLog.Debugf("untar %s: %s", j.event.Event.AbsLoc, ej.key)
+		er := o.push(done, ej, pi, lg)
+		res.collect(er)
+		if er.status != Uploaded {
+			er.keys, er.destinations = res.keys, res.destinations
+			return er
+		}
+		res.response = er.response
+		res.keys = append(res.keys, ej.key)
+	}
+	return o.keepArchive(done, f, j, res, pi, lg)
+}
+
+// keepArchive additionally uploads the archive file unchanged if configured, under the archive
+// key with the compression suffix stripped from the source path appended again
+func (o *FsEventOps) keepArchive(done <-chan struct{}, f *os.File, j pushJob, res *ResultInfo, pi *EventPushInfo, lg *logrus.Logger) *ResultInfo {
+	if !pi.Archive.Keep {
+		return res
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return &ResultInfo{eventInfo: j.event, keys: res.keys, status: Failed, err: errors.Wrapf(err, "keep %s", j.event.Event.AbsLoc)}
+	}
+	j.key += strings.TrimPrefix(filepath.Base(j.event.Event.AbsLoc), path.Base(j.source))
+	j.body = f
+	j.contentType = ""
+	j.metadata = nil
+	j.raw = true
+	r := o.push(done, j, pi, lg)
+	res.collect(r)
+	if r.status != Uploaded {
+		r.keys, r.destinations = res.keys, res.destinations
+		return r
+	}
+	res.keys = append(res.keys, j.key)
+	return res
+}
+
+// collect adds the failed destinations of an archive entry to the result of the archive
+func (res *ResultInfo) collect(r *ResultInfo) {
+	for _, d := range r.destinations {
+		if d.status != Uploaded {
+			res.destinations = append(res.destinations, d)
+		}
+	}
+}
+
+//!-stage-2
+
+// isTar reports whether the buffered stream starts with a POSIX or GNU tar header
+func isTar(br *bufio.Reader) bool {
+	b, _ := br.Peek(tarSniffLen)
+	return len(b) == tarSniffLen && bytes.HasPrefix(b[257:], []byte("ustar"))
+}
+
+// archiveEntries returns the entries to upload, it rejects archives exceeding the limits
+// and entries which would escape the archive prefix
+func archiveEntries(files []*zip.File, a Archive) ([]*zip.File, error) {
+	var total uint64
+	res := make([]*zip.File, 0, len(files))
+	for _, zf := range files {
+		if _, err := entryName(zf.Name); err != nil {
+			return nil, err
+		}
+		if zf.FileInfo().IsDir() && a.SkipDirs {
+			continue
+		}
+		total += zf.UncompressedSize64
+		if a.MaxSize > 0 && total > uint64(a.MaxSize) {
+			return nil, errors.Errorf("uncompressed size exceeds %d bytes", a.MaxSize)
+		}
+		res = append(res, zf)
+	}
+	if a.MaxEntries > 0 && len(res) > a.MaxEntries {
+		return nil, errors.Errorf("more than %d entries", a.MaxEntries)
+	}
+	return res, nil
+}
+
+// entryName validates the path of an archive entry, it must stay below the archive prefix
+func entryName(name string) (string, error) {
+	n := strings.TrimPrefix(strings.TrimSuffix(name, "/"), "./")
+	if n == "" || path.IsAbs(n) || path.Clean(n) != n || n == ".." || strings.HasPrefix(n, "../") {
+		return "", errors.Errorf("invalid entry name %q", name)
+	}
+	return n, nil
+}
+
+// entryMetadata returns the user metadata preserving the modification time of an entry
+func entryMetadata(t time.Time) map[string]string {
+	if t.IsZero() {
+		return nil
+	}
+	return map[string]string{"Mtime": t.UTC().Format(time.RFC3339)}
+}
@@ -0,0 +1,142 @@
+package event
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// errChecksumMismatch marks stored objects differing from the bytes streamed, the source file
+// is kept whatever the removal policy
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// errUnverified marks stored objects whose ETag could not be fetched, e.g. without the
+// permission to Head them, the upload counts as done
+var errUnverified = errors.New("not verified")
+
+// verifyTimeout limits the Head request fetching the ETag of a stored object
+const verifyTimeout = time.Minute
+
+// Integrity controls the verification of stored objects. The S3 client sends the Content-MD5
+// of every request on its own, the ETag comparison additionally covers the object as a whole.
+type Integrity struct {
+	Verify   bool // compare the ETag of each stored object with the MD5 of the bytes streamed
+	Metadata bool // store the SHA-256 of the source file as received, not of the stored object, as Source-Sha256 metadata
+}
+
+// checksums hashes the bytes streamed to the destinations with MD5, it also keeps the MD5 of
+// each part of an S3 multipart upload to derive its ETag
+type checksums struct {
+	md5     hash.Hash
+	part    hash.Hash
+	partLen int64
+	parts   [][]byte
+	size    int64
+}
+
+func newChecksums() *checksums {
+	return &checksums{md5: md5.New(), part: md5.New()}
+}
+
+// Write adds p to all hashes, it never fails
+func (c *checksums) Write(p []byte) (int, error) {
+	n := len(p)
+	c.md5.Write(p)
+	c.size += int64(n)
+	for len(p) > 0 {
+		m := len(p)
+		if rest := s3PartSize - c.partLen; int64(m) > rest {
+			m = int(rest)
+		}
+		c.part.Write(p[:m])
+		c.partLen += int64(m)
+		p = p[m:]
+		if c.partLen == s3PartSize {
+			c.parts = append(c.parts, c.part.Sum(nil))
+			c.part.Reset()
+			c.partLen = 0
+		}
+	}
+	return n, nil
+}
+
+// etag returns the ETag of the bytes streamed, stored in the given number of parts
+func (c *checksums) etag(parts int) string {
+	if parts == 0 {
+		return hex.EncodeToString(c.md5.Sum(nil))
+	}
+	sums := c.parts
+	if c.partLen > 0 {
+		sums = append(sums[:len(sums):len(sums)], c.part.Sum(nil))
+	}
+	h := md5.New()
+	for _, s := range sums {
+		h.Write(s)
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(h.Sum(nil)), len(sums))
+}
+
+func (c *checksums) String() string {
+	return fmt.Sprintf("size %d md5 %s", c.size, hex.EncodeToString(c.md5.Sum(nil)))
+}
+
+// verify compares the ETag of the stored object with the bytes streamed, the ETag is taken from
+// the Put response or else fetched with Head. Objects without ETag or encrypted with SSE-KMS or
+// SSE-C, whose ETag is no MD5, are not verified, neither are objects the bucket encrypted with
+// SSE-KMS by default. A failing Head returns errUnverified.
+func verify(r *destResult, obj Object, c *checksums) error {
+	if obj.Encryption.SSE == s3.ServerSideEncryptionAwsKms || len(obj.Encryption.CustomerKey) > 0 {
+		return nil
+	}
+	etag := r.response.ETag
+	if etag == "" {
+		h, ok := r.dest.(Header)
+		if !ok {
+			return nil
+		}
+		ctx, cancelFn := context.WithTimeout(context.Background(), verifyTimeout)
+		defer cancelFn()
+		info, err := h.Head(ctx, obj.Key)
+		if err != nil {
+			return errors.Wrapf(errUnverified, "head %s, %s", obj.Key, err)
+		}
+		if strings.HasPrefix(info.SSE, s3.ServerSideEncryptionAwsKms) {
+			return nil
+		}
+		etag = info.ETag
+	}
+	parts := 0
+	if i := strings.LastIndex(etag, "-"); i >= 0 {
+		n, err := strconv.Atoi(etag[i+1:])
+		if err != nil {
+			return errors.Wrapf(errChecksumMismatch, "etag %s of %s", etag, obj.Key)
+		}
+		parts = n
+	}
+	if exp := c.etag(parts); etag != exp {
+		return errors.Wrapf(errChecksumMismatch, "etag %s of %s, want %s", etag, obj.Key, exp)
+	}
+	return nil
+}
+
+// sourceSum returns the hex encoded SHA-256 of the file and rewinds it
+func sourceSum(f io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,103 @@
+package event
+
+import (
+	"os"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// settling is an event waiting for its file to settle, seen is the last time the file changed
+type settling struct {
+	e    EventInfo
+	seen time.Time
+}
+
+//!+stage-1
+
+// debounce sits between stage-1 and the gate and holds every event back until the size and
+// modification time of its file did not change for pi.Settle.
+//
+// Repeated CloseWrite events of a waiting file are coalesced into one, files removed or
+// renamed meanwhile, e.g. the temporary files of rsync, are dropped. Without Settle events
+// are passed on as they come.
+func (o *FsEventOps) debounce(in <-chan EventInfo, out chan<- EventInfo, pi *EventPushInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithField("stage", 1)
+	defer close(out)
+	if pi.Settle <= 0 {
+		for e := range in {
+			out <- e
+		}
+		return
+	}
+	expired := make(chan string)
+	pending := make(map[string]*settling)
+
+	drop := func(e EventInfo) {
+		if err := pi.Queue.Done(e); err != nil {
+			ctxLog.Errorf("journal %s", err)
+		}
+		pi.inflight.done()
+	}
+	wait := func(p string, d time.Duration) {
+		if !pi.after(d, func() { expired <- p }) {
+			// stopping, the journal keeps the event for the next run
+			delete(pending, p)
+			pi.inflight.done()
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-in:
+			if !ok {
+				return
+			}
+			p := e.Event.AbsLoc
+			if s, ok := pending[p]; ok {
+				// another CloseWrite of a settling file, only possible without journal
+				ctxLog.Debugf("debounce %s coalesced", p)
+				s.e, s.seen = e, time.Now()
+				pi.inflight.done()
+				continue
+			}
+			pending[p] = &settling{e: e, seen: time.Now()}
+			wait(p, pi.Settle)
+		case p := <-expired:
+			s, ok := pending[p]
+			if !ok {
+				continue
+			}
+			fi, err := o.FsInfo(p)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					ctxLog.Warnf("debounce %s", err)
+				}
+				ctxLog.Debugf("debounce %s gone", p)
+				delete(pending, p)
+				drop(s.e)
+				continue
+			}
+			if changed(fi, s.e) {
+				fsEv := &FsEvent{
+					Event: fsnotify.Event{Name: p, Op: fsnotify.CloseWrite},
+					Ops:   o,
+				}
+				if ev, err := fsEv.Info(); err == nil {
+					s.e = *ev
+				}
+				s.seen = time.Now()
+			}
+			if d := pi.Settle - time.Since(s.seen); d > 0 {
+				ctxLog.Debugf("debounce %s still changing", p)
+				wait(p, d)
+				continue
+			}
+			delete(pending, p)
+			out <- s.e
+		}
+	}
+}
+
+//!-stage-1
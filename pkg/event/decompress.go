@@ -0,0 +1,121 @@
+package event
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// sniffLen is the number of leading bytes fType reads to detect the file type
+const sniffLen = 32
+
+// Decompressor wraps a compressed byte stream into its decompressed stream
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// decoder is a single entry of the decompressor registry
+type decoder struct {
+	mimeType string
+	magic    []byte
+	suffixes []string
+	open     Decompressor
+}
+
+var (
+	decodersMu sync.RWMutex
+	decoders   []decoder
+)
+
+// RegisterDecompressor registers a Decompressor for streams starting with magic. Files
+// detected this way get mimeType as their file type, and the first matching suffix is stripped
+// from their S3 key. Later registrations take precedence over earlier ones, including the
+// built-in gzip, bzip2, xz and zstd decompressors.
+func RegisterDecompressor(mimeType string, magic []byte, suffixes []string, d Decompressor) {
+	if len(magic) == 0 || len(magic) > sniffLen {
+		panic("event: RegisterDecompressor magic must be 1 to 32 bytes")
+	}
+	if d == nil {
+		panic("event: RegisterDecompressor decompressor is nil")
+	}
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders = append(decoders, decoder{
+		mimeType: mimeType,
+		magic:    append([]byte(nil), magic...),
+		suffixes: append([]string(nil), suffixes...),
+		open:     d,
+	})
+}
+
+// sniffDecoder returns the most recently registered decoder matching the head of a file
+func sniffDecoder(head []byte) *decoder {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	for i := len(decoders) - 1; i >= 0; i-- {
+		if bytes.HasPrefix(head, decoders[i].magic) {
+			d := decoders[i]
+			return &d
+		}
+	}
+	return nil
+}
+
+// decoderFor returns the most recently registered decoder of the MIME type
+func decoderFor(mimeType string) *decoder {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	for i := len(decoders) - 1; i >= 0; i-- {
+		if decoders[i].mimeType == mimeType {
+			d := decoders[i]
+			return &d
+		}
+	}
+	return nil
+}
+
+// trimSuffixes strips the first registered compression suffix from the key
+func trimSuffixes(key string) string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	for i := len(decoders) - 1; i >= 0; i-- {
+		for _, s := range decoders[i].suffixes {
+			if strings.HasSuffix(key, s) {
+				return strings.TrimSuffix(key, s)
+			}
+		}
+	}
+	return key
+}
+
+func init() {
+	RegisterDecompressor("application/x-gzip", []byte{0x1f, 0x8b, 0x08}, []string{".gzip", ".gz"},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		})
+	RegisterDecompressor("application/x-bzip2", []byte("BZh"), []string{".bzip2", ".bz2"},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(r)), nil
+		})
+	RegisterDecompressor("application/x-xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, []string{".xz"},
+		func(r io.Reader) (io.ReadCloser, error) {
+			xr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return ioutil.NopCloser(xr), nil
+		})
+	RegisterDecompressor("application/zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, []string{".zstd", ".zst"},
+		func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return zr.IOReadCloser(), nil
+		})
+}
@@ -0,0 +1,333 @@
+package event
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+)
+
+// Destination stores the objects produced by the pipeline. Implementations may additionally
+// implement Header and Deleter.
+type Destination interface {
+	Put(ctx context.Context, obj *Object) (*PutOutput, error)
+	String() string
+}
+
+// Header is implemented by destinations able to describe a stored object
+type Header interface {
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+}
+
+// Deleter is implemented by destinations able to remove a stored object
+type Deleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// ErrNotFound is returned by Head if the object does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Object is a single object handed to a Destination
+type Object struct {
+	Key             string
+	Body            io.Reader
+	ContentType     string // empty if unknown
+	ContentEncoding string // empty if not encoded
+	Metadata        map[string]string
+	Encryption      Encryption // server-side encryption, ignored by destinations without
+	StorageClass    string     // empty for the default class
+	ACL             string     // canned ACL, empty for the bucket default
+	Tags            map[string]string
+
+	stall *stallWatch // progress of the upload, nil if not watched
+}
+
+// PutOutput describes a stored object
+type PutOutput struct {
+	Location  string
+	ETag      string // empty if the destination does not report it on Put
+	VersionID string
+}
+
+// ObjectInfo is returned by Header.Head
+type ObjectInfo struct {
+	Key      string
+	Size     int64
+	ETag     string
+	SSE      string // server-side encryption applied, empty if unknown
+	Metadata map[string]string
+}
+
+// stallAware is implemented by the built-in destinations reporting upload progress on their own
+type stallAware interface {
+	watchesStall()
+}
+
+//!+S3
+
+// s3PartSize is the part size of multipart uploads, it determines the ETag of large objects
+const s3PartSize = 64 * 1024 * 1024 // 64MB per part
+
+// S3Destination stores objects in an S3 bucket
+type S3Destination struct {
+	svc    *s3.S3
+	bucket string
+}
+
+// NewS3Destination returns a Destination storing objects in the bucket
+func NewS3Destination(svc *s3.S3, bucket string) *S3Destination {
+	return &S3Destination{svc: svc, bucket: bucket}
+}
+
+// NewMinIODestination returns a Destination for an S3-compatible endpoint like MinIO, with
+// path-style addressing and static credentials. Further configs, e.g. an HTTP client trusting
+// a private CA, are merged in order.
+func NewMinIODestination(endpoint string, region string, accessKey string, secretKey string, bucket string, cfgs ...*aws.Config) (*S3Destination, error) {
+	if region == "" {
+		region = "us-east-1"
+	}
+	sess, err := session.NewSession(append([]*aws.Config{{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(region),
+		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(strings.HasPrefix(endpoint, "http://")),
+	}}, cfgs...)...)
+	if err != nil {
+		return nil, errors.Wrap(err, "NewMinIODestination")
+	}
+	return NewS3Destination(s3.New(sess), bucket), nil
+}
+
+func (d *S3Destination) String() string {
+	return "s3://" + d.bucket
+}
+
+func (d *S3Destination) watchesStall() {}
+
+// Put uploads the object, large bodies in parts of 64MB
+func (d *S3Destination) Put(ctx context.Context, obj *Object) (*PutOutput, error) {
+	uploader := s3manager.NewUploaderWithClient(d.svc, func(u *s3manager.Uploader) {
+		u.PartSize = s3PartSize
+	})
+	// The idle timeout only aborts the upload if no bytes have moved
+	opts := make([]func(*s3manager.Uploader), 0)
+	if obj.stall != nil {
+		opts = append(opts, s3manager.WithUploaderRequestOptions(obj.stall.option()))
+	}
+	in := &s3manager.UploadInput{
+		Body:   obj.Body,
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(obj.Key),
+	}
+	if obj.ContentType != "" {
+		in.ContentType = aws.String(obj.ContentType)
+	}
+	if obj.ContentEncoding != "" {
+		in.ContentEncoding = aws.String(obj.ContentEncoding)
+	}
+	if len(obj.Metadata) > 0 {
+		in.Metadata = aws.StringMap(obj.Metadata)
+	}
+	if obj.StorageClass != "" {
+		in.StorageClass = aws.String(obj.StorageClass)
+	}
+	if obj.ACL != "" {
+		in.ACL = aws.String(obj.ACL)
+	}
+	if len(obj.Tags) > 0 {
+		v := url.Values{}
+		for k, t := range obj.Tags {
+			v.Set(k, t)
+		}
+		in.Tagging = aws.String(v.Encode())
+	}
+	if e := obj.Encryption; e.SSE != "" {
+		in.ServerSideEncryption = aws.String(e.SSE)
+		if e.KMSKeyID != "" {
+			in.SSEKMSKeyId = aws.String(e.KMSKeyID)
+		}
+		if e.BucketKey {
+			opts = append(opts, s3manager.WithUploaderRequestOptions(bucketKeyOption))
+		}
+	} else if len(e.CustomerKey) > 0 {
+		in.SSECustomerAlgorithm = aws.String(s3.ServerSideEncryptionAes256)
+		in.SSECustomerKey = aws.String(string(e.CustomerKey)) // the SDK adds its MD5
+	}
+	r, err := uploader.UploadWithContext(ctx, in, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &PutOutput{
+		Location:  r.Location,
+		VersionID: aws.StringValue(r.VersionID),
+	}, nil
+}
+
+// Head returns size, ETag, server-side encryption and user metadata of the object
+func (d *S3Destination) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	r, err := d.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:      key,
+		Size:     aws.Int64Value(r.ContentLength),
+		ETag:     strings.Trim(aws.StringValue(r.ETag), `"`),
+		SSE:      aws.StringValue(r.ServerSideEncryption),
+		Metadata: aws.StringValueMap(r.Metadata),
+	}, nil
+}
+
+// Delete removes the object
+func (d *S3Destination) Delete(ctx context.Context, key string) error {
+	_, err := d.svc.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(d.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// bucketKeyOption enables the S3 Bucket Key of SSE-KMS uploads, the header is set on the
+// requests creating the object since the SDK does not model it yet
+func bucketKeyOption(r *request.Request) {
+	switch r.Operation.Name {
+	case "PutObject", "CreateMultipartUpload":
+		r.HTTPRequest.Header.Set("X-Amz-Server-Side-Encryption-Bucket-Key-Enabled", "true")
+	}
+}
+
+//!-S3
+
+//!+Dir
+
+// DirDestination stores objects as files below a local or NFS mounted directory, the key
+// being the relative path. Content type, encoding, metadata, encryption and storage settings
+// are not kept.
+type DirDestination struct {
+	root string
+}
+
+// NewDirDestination returns a Destination storing objects below root
+func NewDirDestination(root string) *DirDestination {
+	return &DirDestination{root: filepath.Clean(root)}
+}
+
+func (d *DirDestination) String() string {
+	return "file://" + d.root
+}
+
+// path returns the file of the key, keys must stay below the root directory
+func (d *DirDestination) path(key string) (string, error) {
+	p := filepath.Join(d.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(p, d.root+string(filepath.Separator)) {
+		return "", errors.Errorf("key %q outside of %s", key, d.root)
+	}
+	return p, nil
+}
+
+// Put writes the object to a temporary file first and renames it once complete, its ETag
+// is the hex encoded MD5 of the content like for single part S3 uploads
+func (d *DirDestination) Put(ctx context.Context, obj *Object) (*PutOutput, error) {
+	p, err := d.path(obj.Key)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(obj.Key, "/") {
+		return &PutOutput{Location: p}, os.MkdirAll(p, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name()) // no-op once renamed
+
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), ctxReader{ctx: ctx, r: obj.Body}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return nil, err
+	}
+	return &PutOutput{Location: p, ETag: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Head returns size and MD5 ETag of the stored file
+func (d *DirDestination) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	p, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &ObjectInfo{Key: key, Size: n, ETag: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// Delete removes the stored file
+func (d *DirDestination) Delete(ctx context.Context, key string) error {
+	p, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ctxReader stops reading once the context is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+//!-Dir
@@ -0,0 +1,62 @@
+package event
+
+import (
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// encoder is a compression format objects may be re-encoded to before the upload
+type encoder struct {
+	suffix   string // appended to the S3 key
+	encoding string // S3 ContentEncoding
+	writer   func(w io.Writer) (io.WriteCloser, error)
+}
+
+var encoders = map[string]encoder{
+	"gzip": {".gz", "gzip", func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	}},
+	"zstd": {".zst", "zstd", func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}},
+}
+
+// encode re-encodes the job body in the output compression of its user and adjusts key and
+// content encoding. The returned stop function must be called once the body is not read any
+// longer, it waits for the encoding goroutine to release the original body.
+func (j *pushJob) encode() (stop func(), err error) {
+	c := j.user.Output.Compression
+	if j.raw || c == "" || c == "none" {
+		return func() {}, nil
+	}
+	enc, ok := encoders[c]
+	if !ok {
+		return nil, errors.Errorf("unknown output compression %q", c)
+	}
+	pr, pw := io.Pipe()
+	w, err := enc.writer(pw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "output compression %s", c)
+	}
+	body := j.body
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := io.Copy(w, body)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	j.body = pr
+	j.key += enc.suffix
+	j.contentEncoding = enc.encoding
+	return func() {
+		pr.CloseWithError(errors.New("upload finished"))
+		<-done
+	}, nil
+}
@@ -0,0 +1,40 @@
+package event
+
+import (
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// Encryption is the server-side encryption requested for the objects of a user
+type Encryption struct {
+	SSE         string // empty, AES256 or aws:kms
+	KMSKeyID    string // key ID, alias or ARN of aws:kms, empty for the AWS managed key
+	BucketKey   bool   // reduce KMS requests with an S3 Bucket Key, aws:kms only
+	CustomerKey []byte // 256 bit key of SSE-C, excludes SSE
+}
+
+// Validate reports settings S3 would reject or silently ignore
+func (e Encryption) Validate() error {
+	switch e.SSE {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		return errors.Errorf("sse %q, want %s or %s", e.SSE, s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms)
+	}
+	if e.SSE != s3.ServerSideEncryptionAwsKms {
+		if e.KMSKeyID != "" {
+			return errors.Errorf("kms key %s configured without sse %s", e.KMSKeyID, s3.ServerSideEncryptionAwsKms)
+		}
+		if e.BucketKey {
+			return errors.Errorf("bucket key configured without sse %s", s3.ServerSideEncryptionAwsKms)
+		}
+	}
+	if len(e.CustomerKey) > 0 {
+		if e.SSE != "" {
+			return errors.Errorf("customer key configured along with sse %s", e.SSE)
+		}
+		if len(e.CustomerKey) != 32 {
+			return errors.Errorf("customer key has %d bytes, want 32", len(e.CustomerKey))
+		}
+	}
+	return nil
+}
@@ -1,12 +1,13 @@
 package event
 
 import (
+	"context"
 	"io"
 	"os"
+	"sync"
+	"text/template"
 	"time"
 
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 )
@@ -21,13 +22,42 @@ type FsEvent struct {
 type FsEventOperations interface {
 	EventSrc(path string) (string, error)
 	FsInfo(path string) (os.FileInfo, error)
-	NewWatcher(info *EventPushInfo, logger *logrus.Logger)
-	fType(path string, logger *logrus.Logger) (string, *io.Reader)
-	listen(watcher *fsnotify.Watcher, targetevents chan<- EventInfo, logger *logrus.Logger)
+	NewWatcher(ctx context.Context, info *EventPushInfo, logger *logrus.Logger) error
+	Reload(info *EventPushInfo, next *EventPushInfo, logger *logrus.Logger) error
+	Retry(info *EventPushInfo, logger *logrus.Logger) error
+	fType(file io.Reader) (string, io.Reader, error)
+	listen(tree *watchTree, pinfo *EventPushInfo, targetevents chan<- EventInfo, logger *logrus.Logger)
+	addTree(tree *watchTree, root string, logger *logrus.Logger) error
+	owner(path string, pinfo *EventPushInfo) *UserInfo
+	sourceDir(path string, pinfo *EventPushInfo) string
+	backfill(root string, pinfo *EventPushInfo, targetevents chan<- EventInfo, logger *logrus.Logger)
+	settled(event EventInfo, pinfo *EventPushInfo, targetevents chan<- EventInfo, logger *logrus.Logger)
 	controlWorkers(targetevents <-chan EventInfo, pinfo *EventPushInfo, logger *logrus.Logger)
-	pushS3(done <-chan struct{}, bytes io.Reader, pinfo EventPushInfo, einfo EventInfo, logger *logrus.Logger) <-chan *ResultInfo
+	process(done <-chan struct{}, einfo EventInfo, pinfo *EventPushInfo, logger *logrus.Logger)
+	put(done <-chan struct{}, job pushJob, pinfo *EventPushInfo, logger *logrus.Logger) <-chan *ResultInfo
+	putOne(ctx context.Context, dest Destination, obj Object, idle time.Duration, logger *logrus.Logger) destResult
+	push(done <-chan struct{}, job pushJob, pinfo *EventPushInfo, logger *logrus.Logger) *ResultInfo
+	finish(result *ResultInfo, pinfo *EventPushInfo, logger *logrus.Logger)
+	objectKey(job pushJob, contentType string) (string, error)
+	renderKey(job pushJob, contentType string) (string, error)
+	unzip(done <-chan struct{}, archive *os.File, job pushJob, pinfo *EventPushInfo, logger *logrus.Logger) *ResultInfo
+	untar(done <-chan struct{}, archive *os.File, stream io.Reader, job pushJob, pinfo *EventPushInfo, logger *logrus.Logger) *ResultInfo
+	keepArchive(done <-chan struct{}, archive *os.File, job pushJob, result *ResultInfo, pinfo *EventPushInfo, logger *logrus.Logger) *ResultInfo
 	reduceEventPath(p string, cfgp *string) (string, error)
 	removeF(event EventInfo) error
+	enqueue(einfo EventInfo, pinfo *EventPushInfo, targetevents chan<- EventInfo, logger *logrus.Logger)
+	replay(pinfo *EventPushInfo, targetevents chan<- EventInfo, logger *logrus.Logger)
+	results(targetevents chan<- EventInfo, pinfo *EventPushInfo, logger *logrus.Logger)
+	repush(einfo EventInfo, targetevents chan<- EventInfo, pinfo *EventPushInfo, logger *logrus.Logger)
+	reschedule(result *ResultInfo, targetevents chan<- EventInfo, pinfo *EventPushInfo, logger *logrus.Logger)
+	deadLetter(einfo EventInfo, cause error, pinfo *EventPushInfo) error
+	quarantine(einfo EventInfo, cause error, pinfo *EventPushInfo) error
+	moveAside(einfo EventInfo, dir string, ext string, cause error, pinfo *EventPushInfo) error
+	restore(path string) (*EventInfo, error)
+	debounce(in <-chan EventInfo, out chan<- EventInfo, pinfo *EventPushInfo, logger *logrus.Logger)
+	gate(targetevents <-chan EventInfo, gated chan<- EventInfo, restored bool, pinfo *EventPushInfo, logger *logrus.Logger)
+	release(marker string, user *UserInfo, logger *logrus.Logger) []EventInfo
+	pushSidecar(done <-chan struct{}, job pushJob, result *ResultInfo, pinfo *EventPushInfo, logger *logrus.Logger) *ResultInfo
 }
 
 // Implements the FsEventOperations interface
@@ -41,8 +71,9 @@ type EventInfo struct {
 
 // Implements child of parent EventInfo
 type Event struct {
-	AbsLoc string `json:"absloc"`
-	Op     string `json:"op"`
+	AbsLoc  string `json:"absloc"`
+	Op      string `json:"op"`
+	Attempt int    `json:"attempt,omitempty"`
 }
 
 // Implements child of parent EventInfo
@@ -55,16 +86,178 @@ type Meta struct {
 
 // EventPushInfo contains the common data for SftpPush Stages
 type EventPushInfo struct {
-	Session   *s3.S3
-	Userpath  *string
-	Watchdirs []string
-	Bucket    *string
-	Key       string
-	Results   chan *ResultInfo
+	Destination Destination // used for all users without a Target.Destination
+	Userpath    *string
+	Watchdirs   []string
+	Users       map[string]*UserInfo // source directory -> owning user
+	Backfill    bool                 // scan Watchdirs for files left over from a previous run
+	MinAge      time.Duration        // minimum age of backfilled files
+	Settle      time.Duration        // time the size and mtime of a file must be stable before it is processed
+	Workers     int                  // number of files processed concurrently
+	Ordered     bool                 // process the files of a source directory in order of arrival
+	Retry       Retry
+	Archive     Archive
+	Integrity   Integrity
+	Queue       *Journal // durable record of all pending events, nil disables it
+	Results     chan *ResultInfo
+
+	Grace time.Duration // time granted to uploads in progress on shutdown, zero does not wait
+
+	inflight counter       // events handed to stage-2 without a final result yet
+	quit     chan struct{} // closed once the pipeline stops accepting new events
+	mu       sync.Mutex
+	timers   map[*time.Timer]bool // pending retries
+
+	cfg    sync.RWMutex     // guards Watchdirs and Users against Reload
+	tree   *watchTree       // watches of the running NewWatcher
+	events chan<- EventInfo // stage-1 channel of the running NewWatcher
+}
+
+// Retry controls how often and how fast failed uploads are attempted again
+type Retry struct {
+	Attempts   int           // total number of upload attempts before a file is dead-lettered
+	Backoff    time.Duration // delay before the first retry, doubled for each further one
+	MaxBackoff time.Duration
+}
+
+// Archive controls how archives are unpacked into one object per entry
+type Archive struct {
+	Keep       bool  // upload the archive itself as well
+	SkipDirs   bool  // do not create empty marker objects for directory entries
+	MaxSize    int64 // maximum total uncompressed size of all entries in bytes
+	MaxEntries int   // maximum number of entries pushed from a single archive
+}
+
+// Types restricts the MIME types pushed for a user, entries are either full types like
+// "text/csv" or wildcards like "text/*". Deny takes precedence, an empty Allow permits all.
+type Types struct {
+	Allow []string
+	Deny  []string
+}
+
+// DeadLetter is the JSON sidecar stored next to a file which could not be uploaded,
+// quarantined files come with the same sidecar
+type DeadLetter struct {
+	EventInfo EventInfo `json:"eventInfo"`
+	Error     string    `json:"error"`
+	Time      time.Time `json:"time"`
+}
+
+// pushJob holds the per file state handed from stage-2 to stage-3
+type pushJob struct {
+	event  EventInfo
+	user   *UserInfo
+	source string // path relative to the userpath without compression suffix, e.g. user1/data/export.csv
+	key    string
+	body   io.Reader
+
+	contentType     string            // set as S3 ContentType if not empty
+	contentEncoding string            // set as S3 ContentEncoding if not empty
+	metadata        map[string]string // user metadata
+	raw             bool              // upload the body unchanged, without output compression
+	sourceSum       string            // hex SHA-256 of the source file, empty if not computed
+	sidecar         string            // checksum sidecar the source file was verified with
 }
 
 // ResultInfo is the data returned in the results channel
 type ResultInfo struct {
-	response  *s3manager.UploadOutput
+	response  *PutOutput
 	eventInfo EventInfo
+	keys      []string // all objects uploaded for the event
+	// outcome of each destination of the object, the failed destinations of all entries for archives
+	destinations []destResult
+	status       ResultStatus
+	err          error
+	sidecar      string // checksum sidecar removed along with the event file
+}
+
+// ResultStatus describes the outcome of a single upload
+type ResultStatus int
+
+const (
+	// Uploaded means the object was stored and the source file removed
+	Uploaded ResultStatus = iota
+	// Failed means the upload returned an error
+	Failed
+	// Canceled means the upload exceeded its timeout
+	Canceled
+	// Stalled means no bytes were sent within the idle timeout
+	Stalled
+	// Quarantined means the file type was rejected and the file moved to quarantine
+	Quarantined
+	// Changed means the file was rewritten during its upload, it is kept and pushed again
+	Changed
+)
+
+func (s ResultStatus) String() string {
+	switch s {
+	case Uploaded:
+		return "uploaded"
+	case Failed:
+		return "failed"
+	case Canceled:
+		return "canceled"
+	case Stalled:
+		return "stalled"
+	case Quarantined:
+		return "quarantined"
+	case Changed:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// UserInfo contains the per user settings shared by all of the user's source directories
+type UserInfo struct {
+	Name       string
+	Recursive  bool
+	Timeout    Timeout
+	DeadLetter string // directory receiving the user's files once all upload attempts failed
+	Types      Types
+	Sidecar    Sidecar
+	Ready      Ready
+	Quarantine string // directory receiving the user's files of rejected types
+	Output     Output
+	Encryption Encryption
+	Storage    Storage
+	Key        *template.Template // renders the S3 key from KeyData, nil keeps the local layout
+	Target     Target
+}
+
+// Target are the destinations of a user, without any EventPushInfo.Destination is used
+type Target struct {
+	Destinations []Destination // all of them receive a copy of each object
+	Removal      Removal       // successful destinations required to remove the source file
+	Prefix       string        // prepended to all keys of the user
+}
+
+// Removal is the policy deciding when a source file pushed to several destinations is removed
+type Removal int
+
+const (
+	// RemoveAll waits for all destinations to succeed
+	RemoveAll Removal = iota
+	// RemoveAny waits for one destination to succeed
+	RemoveAny
+	// RemoveQuorum waits for the majority of destinations to succeed
+	RemoveQuorum
+)
+
+// Output controls how objects are stored
+type Output struct {
+	Compression string // none, gzip or zstd applied to the decoded content before the upload
+}
+
+// Timeout limits the duration of a single upload, zero values disable the respective limit
+type Timeout struct {
+	Base  time.Duration // fixed part of the upload timeout
+	PerMB time.Duration // added for each started MB of the source file
+	Idle  time.Duration // abort once no bytes were sent for this long
+}
+
+// watchTree keeps track of all directories added to the fsnotify.Watcher
+type watchTree struct {
+	watcher *fsnotify.Watcher
+	mu      sync.Mutex
+	dirs    map[string]bool
 }
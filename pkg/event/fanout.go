@@ -0,0 +1,164 @@
+package event
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// destResult is the outcome of a single destination of a job
+type destResult struct {
+	dest     Destination
+	response *PutOutput
+	status   ResultStatus
+	err      error
+}
+
+// destinations returns the destinations of the job, the EventPushInfo.Destination if the user has none
+func (j pushJob) destinations(pi *EventPushInfo) []Destination {
+	if len(j.user.Target.Destinations) > 0 {
+		return j.user.Target.Destinations
+	}
+	if pi.Destination != nil {
+		return []Destination{pi.Destination}
+	}
+	return nil
+}
+
+//!+stage-3
+
+// putOne stores the object at a single destination, the idle timeout applies to each
+// destination on its own
+func (o FsEventOps) putOne(ctx context.Context, d Destination, obj Object, idle time.Duration, lg *logrus.Logger) destResult {
+	ctxLog := lg.WithField("stage", 3)
+	ctx, cancelFn := context.WithCancel(ctx)
+	defer cancelFn()
+
+	// The idle timeout only aborts the upload if no bytes have moved
+	if idle > 0 {
+		obj.stall = newStallWatch(ctx, idle, cancelFn)
+		if _, ok := d.(stallAware); !ok {
+			obj.Body = obj.stall.reader(obj.Body)
+		}
+	}
+	// The Context will interrupt the request if the timeout expires.
+	r, err := d.Put(ctx, &obj)
+	res := destResult{dest: d, response: r, status: Uploaded, err: err}
+	if err != nil {
+		res.status = Failed
+		if ctx.Err() != nil {
+			// canceled by the timeout or the stall watch
+			res.status = Canceled
+			if obj.stall != nil && obj.stall.isStalled() {
+				res.status = Stalled
+			}
+			ctxLog.Errorf("request's context canceled (%s), %s %s", res.status, d, err)
+		} else {
+			ctxLog.Warnf("Put %s %s", d, err)
+		}
+	}
+	return res
+}
+
+//!-stage-3
+
+// satisfied reports whether ok successful uploads out of n allow to remove the source file
+func (r Removal) satisfied(ok int, n int) bool {
+	switch r {
+	case RemoveAny:
+		return ok > 0
+	case RemoveQuorum:
+		return ok > n/2
+	}
+	return ok == n && n > 0
+}
+
+// combine derives the overall status of a job from its destination results, the status of
+// the first failed destination is reported if the removal policy is not satisfied
+func combine(r Removal, dests []destResult) (*PutOutput, ResultStatus, error) {
+	var resp *PutOutput
+	status := Failed
+	ok := 0
+	msgs := make([]string, 0)
+	for _, d := range dests {
+		if d.status == Uploaded {
+			ok++
+			if resp == nil {
+				resp = d.response
+			}
+			continue
+		}
+		if len(msgs) == 0 {
+			status = d.status
+		}
+		msgs = append(msgs, d.dest.String()+": "+d.err.Error())
+	}
+	if r.satisfied(ok, len(dests)) {
+		return resp, Uploaded, nil
+	}
+	if len(dests) == 0 {
+		return nil, Failed, errors.New("no destination")
+	}
+	return nil, status, errors.Errorf("%d of %d destinations failed: %s", len(dests)-ok, len(dests), strings.Join(msgs, "; "))
+}
+
+// fanOut copies r into n readers, one per destination. The returned close function must be
+// called with the index of a reader once its destination stopped reading, a closed reader
+// no longer holds back the others. wait blocks until r is not read any longer.
+func fanOut(r io.Reader, n int) (readers []io.Reader, closeFn func(i int), wait func()) {
+	if n == 1 {
+		return []io.Reader{r}, func(int) {}, func() {}
+	}
+	prs := make([]*io.PipeReader, n)
+	pws := make([]*io.PipeWriter, n)
+	readers = make([]io.Reader, n)
+	for i := range prs {
+		prs[i], pws[i] = io.Pipe()
+		readers[i] = prs[i]
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		live := make([]bool, n)
+		for i := range live {
+			live[i] = true
+		}
+		buf := make([]byte, 32*1024)
+		for {
+			m, err := r.Read(buf)
+			if m > 0 {
+				alive := 0
+				for i, pw := range pws {
+					if !live[i] {
+						continue
+					}
+					if _, werr := pw.Write(buf[:m]); werr != nil {
+						live[i] = false
+						continue
+					}
+					alive++
+				}
+				if alive == 0 {
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				for _, pw := range pws {
+					pw.CloseWithError(err)
+				}
+				return
+			}
+		}
+	}()
+	closeFn = func(i int) {
+		prs[i].CloseWithError(errors.New("destination done"))
+	}
+	return readers, closeFn, func() { <-done }
+}
@@ -0,0 +1,104 @@
+package event
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+//!+stage-1
+
+// gate sits between stage-1 and stage-2 and handles the control files of the users.
+//
+// With readiness markers, events of files not marked ready are dropped and a marker releases
+// the files it marks. Payloads expecting a checksum sidecar are held back until the sidecar
+// arrived or the wait of the user expired. Events of control files are finished by the gate,
+// the files themselves are handled along with their payload.
+//
+// Restored events of Retry were marked ready before they were dead-lettered, their directory
+// level marker may be gone since and they pass without one.
+func (o *FsEventOps) gate(in <-chan EventInfo, out chan<- EventInfo, restored bool, pi *EventPushInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithField("stage", 1)
+	defer close(out)
+	expired := make(chan string)
+	pending := make(map[string]EventInfo)
+
+	drop := func(e EventInfo) {
+		if err := pi.Queue.Done(e); err != nil {
+			ctxLog.Errorf("journal %s", err)
+		}
+		pi.inflight.done()
+	}
+	admit := func(e EventInfo, u *UserInfo) {
+		p := e.Event.AbsLoc
+		if _, ok := pending[p]; ok {
+			// another CloseWrite of a waiting payload, only possible without journal
+			pi.inflight.done()
+			return
+		}
+		if !u.Sidecar.Verify || u.Sidecar.Wait <= 0 || findSidecar(p) != "" {
+			out <- e
+			return
+		}
+		ctxLog.Debugf("gate %s waiting up to %s for its sidecar", p, u.Sidecar.Wait)
+		if !pi.after(u.Sidecar.Wait, func() { expired <- p }) {
+			// stopping, the journal keeps the event for the next run
+			pi.inflight.done()
+			return
+		}
+		pending[p] = e
+	}
+
+	for {
+		select {
+		case e, ok := <-in:
+			if !ok {
+				return
+			}
+			p := e.Event.AbsLoc
+			u := o.owner(p, pi)
+			if u == nil {
+				out <- e
+				continue
+			}
+			if _, ok := u.Ready.isMarker(p); ok {
+				for _, re := range o.release(p, u, lg) {
+					if ok, err := pi.Queue.Add(re); err != nil {
+						ctxLog.Errorf("journal %s, %s", re.Event.AbsLoc, err)
+					} else if !ok {
+						continue // already pending
+					}
+					ctxLog.Debugf("gate %s released by %s", re.Event.AbsLoc, filepath.Base(p))
+					pi.inflight.add(1)
+					admit(re, u)
+				}
+				drop(e)
+				continue
+			}
+			if isSidecar(p, u) {
+				payload := strings.TrimSuffix(p, filepath.Ext(p))
+				if pe, ok := pending[payload]; ok {
+					ctxLog.Debugf("gate %s arrived", p)
+					delete(pending, payload)
+					out <- pe
+				}
+				drop(e)
+				continue
+			}
+			if u.Ready.enabled() && !restored && len(u.Ready.markers(p)) == 0 {
+				ctxLog.Debugf("gate %s not marked ready", p)
+				drop(e)
+				continue
+			}
+			admit(e, u)
+		case p := <-expired:
+			if e, ok := pending[p]; ok {
+				delete(pending, p)
+				out <- e
+			}
+		}
+	}
+}
+
+//!-stage-1
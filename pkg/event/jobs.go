@@ -1,10 +1,11 @@
 package event
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
+	"hash/fnv"
 	"io"
 	"net/http"
 	"os"
@@ -14,10 +15,8 @@ import (
 
 	"github.com/sirupsen/logrus"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/request"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
 )
 
 //!+stage-3
@@ -30,50 +29,145 @@ func (o FsEventOps) removeF(e EventInfo) error {
 	return nil
 }
 
-// PushS3 uploads the source event file byte stream to S3 and removes the file
-func (o FsEventOps) pushS3(done <-chan struct{}, in io.Reader, pi EventPushInfo, ei EventInfo, lg *logrus.Logger) <-chan *ResultInfo {
+// finish removes the event file along with its control files once all of its uploads succeeded
+// and hands the result to stage-4. A file rewritten during its upload is kept, its new content
+// is pushed again.
+func (o *FsEventOps) finish(res *ResultInfo, pi *EventPushInfo, lg *logrus.Logger) {
 	ctxLog := lg.WithField("stage", 3)
+	if res.status == Uploaded {
+		p := res.eventInfo.Event.AbsLoc
+		if fi, err := o.FsInfo(p); err == nil && changed(fi, res.eventInfo) {
+			ctxLog.Infof("finish %s changed during upload, keeping it", p)
+			res.status = Changed
+		} else if err := o.removeF(res.eventInfo); err != nil {
+			ctxLog.Errorf("removeF %s", err)
+		} else {
+			if err := pi.Queue.Done(res.eventInfo); err != nil {
+				ctxLog.Errorf("journal %s", err)
+			}
+			// control files go along with the event file
+			if res.sidecar != "" {
+				if err := os.Remove(res.sidecar); err != nil {
+					ctxLog.Errorf("removeF %s", err)
+				}
+			}
+			if err := clearMarkers(p, o.owner(p, pi)); err != nil {
+				ctxLog.Errorf("clearMarkers %s", err)
+			}
+		}
+	}
+	pi.Results <- res
+}
+
+// push applies the output compression to a single upload, runs it through the put stage
+// and waits for its result
+func (o *FsEventOps) push(done <-chan struct{}, j pushJob, pi *EventPushInfo, lg *logrus.Logger) *ResultInfo {
+	stop, err := j.encode()
+	if err != nil {
+		return &ResultInfo{eventInfo: j.event, status: Failed, err: err}
+	}
+	defer stop()
+	res := &ResultInfo{eventInfo: j.event, status: Canceled, err: errors.New("pipeline stopped")}
+	for n := range o.put(done, j, pi, lg) { // only single result in put chan
+		res = n
+	}
+	return res
+}
+
+// put stores the byte stream of a single job at all destinations of its user in parallel
+func (o FsEventOps) put(done <-chan struct{}, j pushJob, pi *EventPushInfo, lg *logrus.Logger) <-chan *ResultInfo {
 	out := make(chan *ResultInfo)
 	go func() {
 		defer close(out)
-		uploader := s3manager.NewUploaderWithClient(pi.Session, func(u *s3manager.Uploader) {
-			u.PartSize = 64 * 1024 * 1024 // 64MB per part
-		})
+		ctxLog := lg.WithField("stage", 3)
+		dsts := j.destinations(pi)
 
 		// Create a context with a timeout that will abort the upload if it takes
-		// more than the passed in timeout.
+		// more than the size dependent timeout of the user.
 		ctx := context.Background()
 		var cancelFn func()
-		ctx, cancelFn = context.WithTimeout(ctx, time.Duration(3*time.Second))
+		if d := j.user.Timeout.forSize(j.event.Meta.Size); d > 0 {
+			ctx, cancelFn = context.WithTimeout(ctx, d)
+		} else {
+			ctx, cancelFn = context.WithCancel(ctx)
+		}
 		// Ensure the context is canceled to prevent leaking.
 		// See context package for more information, https://golang.org/pkg/context/
 		defer cancelFn()
 
-		// Uploads the object to S3. The Context will interrupt the request if the
-		// timeout expires.
-		r, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-			Body:   in,
-			Bucket: pi.Bucket,
-			Key:    &pi.Key,
-		})
+		tags, err := j.tags()
 		if err != nil {
-			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == request.CanceledErrorCode {
-				// If the SDK can determine the request or retry delay was canceled
-				// by a context the CanceledErrorCode error code will be returned.
-				ctxLog.Errorf("request's context canceled, %s", err)
-			} else {
-				ctxLog.Warnf("PushS3 %s", err)
-			}
-		} else {
 			select {
-			case out <- &ResultInfo{response: r, eventInfo: ei}:
+			case out <- &ResultInfo{eventInfo: j.event, keys: []string{j.key}, status: Failed, err: err}:
 			case <-done:
-				return
 			}
-			if err := o.removeF(ei); err != nil {
-				ctxLog.Errorf("removeF %s", err)
+			return
+		}
+		meta := j.objectMetadata()
+
+		body := j.body
+		var sums *checksums
+		if pi.Integrity.Verify {
+			sums = newChecksums()
+			body = io.TeeReader(body, sums)
+		}
+		bodies, closeBody, wait := fanOut(body, len(dsts))
+		results := make([]destResult, len(dsts))
+		var wg sync.WaitGroup
+		obj := Object{
+			Key:             j.key,
+			ContentType:     j.contentType,
+			ContentEncoding: j.contentEncoding,
+			Metadata:        meta,
+			Encryption:      j.user.Encryption,
+			StorageClass:    j.user.Storage.Class,
+			ACL:             j.user.Storage.ACL,
+			Tags:            tags,
+		}
+		wg.Add(len(dsts))
+		for i, d := range dsts {
+			go func(i int, d Destination) {
+				defer wg.Done()
+				defer closeBody(i)
+				obj := obj
+				obj.Body = bodies[i]
+				results[i] = o.putOne(ctx, d, obj, j.user.Timeout.Idle, lg)
+			}(i, d)
+		}
+		wg.Wait()
+		if len(dsts) > 0 {
+			wait()
+		}
+
+		mismatch := false
+		if sums != nil {
+			ctxLog.Debugf("put %s: %s", j.key, sums)
+			for i := range results {
+				if results[i].status != Uploaded {
+					continue
+				}
+				err := verify(&results[i], obj, sums)
+				if errors.Cause(err) == errUnverified {
+					ctxLog.Warnf("%s %s", results[i].dest, err)
+					continue
+				}
+				if err != nil {
+					ctxLog.Errorf("%s %s", results[i].dest, err)
+					results[i].status, results[i].err = Failed, err
+					mismatch = mismatch || errors.Cause(err) == errChecksumMismatch
+				}
 			}
 		}
+
+		r, status, err := combine(j.user.Target.Removal, results)
+		if mismatch && status == Uploaded {
+			r, status, err = nil, Failed, errors.Wrap(errChecksumMismatch, "source kept")
+		}
+		res := &ResultInfo{response: r, eventInfo: j.event, keys: []string{j.key}, destinations: results, status: status, err: err}
+		select {
+		case out <- res:
+		case <-done:
+		}
 	}()
 	return out
 }
@@ -82,84 +176,320 @@ func (o FsEventOps) pushS3(done <-chan struct{}, in io.Reader, pi EventPushInfo,
 
 //!+stage-2
 
-// FType detects and returns the file type along with the initial file io.Reader
-func (o *FsEventOps) fType(epath string, lg *logrus.Logger) (string, *io.Reader) {
+// FType detects and returns the file type along with the initial file io.Reader, registered
+// decompressors take precedence over the http.DetectContentType sniffing
+func (o *FsEventOps) fType(f io.Reader) (string, io.Reader, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, err
+	}
+	buf = buf[:n]
+	fT := http.DetectContentType(buf)
+	if d := sniffDecoder(buf); d != nil {
+		fT = d.mimeType
+	}
+
+	// glue those bytes back onto the reader
+	r := io.MultiReader(bytes.NewReader(buf), f)
+
+	return fT, r, nil
+}
+
+// process detects the file type of a single event and sends the decompressed byte stream to the put stage,
+// every event results in exactly one ResultInfo
+func (o *FsEventOps) process(done <-chan struct{}, e EventInfo, pi *EventPushInfo, lg *logrus.Logger) {
 	ctxLog := lg.WithFields(logrus.Fields{"stage": 2})
-	f, err := os.Open(epath)
+	p := e.Event.AbsLoc
+	fail := func(err error) {
+		ctxLog.Errorf("%s", err)
+		pi.Results <- &ResultInfo{eventInfo: e, status: Failed, err: err}
+	}
+	f, err := os.Open(p)
 	if err != nil {
-		ctxLog.Warnf("Open %s, %s\n", filepath.Base(epath), err)
+		fail(errors.Wrapf(err, "Open %s", filepath.Base(p)))
+		return
+	}
+	defer f.Close()
+	var sum string
+	if pi.Integrity.Metadata {
+		if sum, err = sourceSum(f); err != nil {
+			fail(errors.Wrapf(err, "checksum %s", filepath.Base(p)))
+			return
+		}
 	}
 
-	buf := make([]byte, 32)
-	if _, err := f.Read(buf); err != nil {
-		ctxLog.Errorf("File Read %s, %s\n", filepath.Base(epath), err)
+	ft, b, err := o.fType(f)
+	if err != nil {
+		fail(errors.Wrapf(err, "File Read %s", filepath.Base(p)))
+		return
+	}
+	j := pushJob{event: e, user: o.owner(p, pi), sourceSum: sum}
+	if j.user == nil {
+		fail(errors.Errorf("no source directory for %s", p))
+		return
+	}
+	j.source, err = o.reduceEventPath(p, pi.Userpath)
+	if err != nil {
+		fail(err)
+		return
+	}
+	j.key = j.source
+	reject := func(err error) {
+		if qerr := o.quarantine(e, err, pi); qerr != nil {
+			fail(errors.Wrap(qerr, err.Error()))
+			return
+		}
+		if j.sidecar != "" {
+			se := EventInfo{Event: Event{AbsLoc: j.sidecar}}
+			if qerr := o.quarantine(se, err, pi); qerr != nil {
+				ctxLog.Errorf("%s", qerr)
+			}
+		}
+		ctxLog.Warnf("quarantine %s, %s", p, err)
+		pi.Results <- &ResultInfo{eventInfo: e, status: Quarantined, err: err}
+	}
+	// the client's checksum applies to the file as it arrived
+	if j.user.Sidecar.Verify {
+		if j.sidecar, err = checkSidecar(p, j.user); err != nil {
+			switch errors.Cause(err) {
+			case errChecksumMismatch, errNoSidecar, errBadSidecar:
+				reject(err)
+			default:
+				fail(errors.Wrapf(err, "sidecar %s", filepath.Base(p)))
+			}
+			return
+		}
+	}
+	// the type check applies to the file as it arrived, e.g. application/x-gzip
+	if mt := mediaType(ft); !j.user.Types.allows(mt) {
+		reject(errors.Errorf("file type %s rejected for user %s", mt, j.user.Name))
+		return
 	}
-	fT := http.DetectContentType(buf)
 
-	// glue those bytes back onto the reader
-	r := io.MultiReader(bytes.NewReader(buf), f)
+	ctxLog.Debugf("fT %s, %s", ft, filepath.Base(p))
+	if ft == "application/zip" {
+		if j.key, err = o.objectKey(j, ft); err != nil {
+			fail(err)
+			return
+		}
+		o.finish(o.pushSidecar(done, j, o.unzip(done, f, j, pi, lg), pi, lg), pi, lg)
+		return
+	}
+	if d := decoderFor(ft); d != nil {
+		rc, err := d.open(b)
+		if err != nil {
+			fail(errors.Wrapf(err, "decompress %s", ft))
+			return
+		}
+		defer rc.Close()
+		if ft, b, err = o.fType(rc); err != nil {
+			fail(errors.Wrapf(err, "decompress %s Read %s", ft, filepath.Base(p)))
+			return
+		}
+	}
+
+	if j.key, err = o.objectKey(j, ft); err != nil {
+		fail(err)
+		return
+	}
 
-	return fT, &r
+	// uncompressed and unknown types are pushed as they are, tar streams entry by entry
+	br := bufio.NewReaderSize(b, tarSniffLen)
+	if isTar(br) {
+		o.finish(o.pushSidecar(done, j, o.untar(done, f, br, j, pi, lg), pi, lg), pi, lg)
+		return
+	}
+	j.body, j.contentType = br, ft
+	o.finish(o.pushSidecar(done, j, o.push(done, j, pi, lg), pi, lg), pi, lg)
 }
 
-// controlWorkers detects the file type and sends the decompressed byte stream to the PushS3 stage
+// controlWorkers distributes the stage-1 events over a pool of pi.Workers concurrent process workers
 func (o *FsEventOps) controlWorkers(in <-chan EventInfo, pi *EventPushInfo, lg *logrus.Logger) {
-	var (
-		wg  sync.WaitGroup
-		gz  io.Reader
-		err error
-	)
-	ctxLog := lg.WithFields(logrus.Fields{"stage": 2})
+	var wg sync.WaitGroup
 	done := make(chan struct{})
 	defer close(done)
-	for e := range in {
-		p := e.Event.AbsLoc
-		ft, b := o.fType(p, lg)
-		switch ft {
-		case "application/x-gzip":
-			lg.Debugf("Stage-2: fT %s, %s\n", ft, filepath.Base(p))
-			gz, err = gzip.NewReader(*b)
-			if err != nil {
-				ctxLog.Errorf("gzip.NewReader, %s", err)
-				done <- struct{}{}
-			}
-		case "application/zip":
-			ctxLog.Debugf("fT %s, %s", ft, filepath.Base(p))
-		default:
-			// if strings.HasPrefix(string(buf), "\x42\x5a\x68") {
-			// 	log.Printf("INFO[*] Stage-1: file type %s, %s\n", ft, filepath.Base(p))
-			// } else {}
-			ctxLog.Warnf("unexpected fT %s, %s", ft, filepath.Base(p))
-		}
-		pi.Key, err = o.reduceEventPath(p, pi.Userpath)
-		if err != nil {
-			ctxLog.Errorf("%s", err)
-			done <- struct{}{}
+
+	n := pi.Workers
+	if n < 1 {
+		n = 1
+	}
+	worker := func(q <-chan EventInfo) {
+		defer wg.Done()
+		for e := range q {
+			o.process(done, e, pi, lg)
 		}
-		wg.Add(1) // only single result in PushS3 chan
-		for n := range o.pushS3(done, gz, *pi, e, lg) {
-			pi.Results <- n
+	}
+
+	if !pi.Ordered {
+		// all workers compete for the next event
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			go worker(in)
 		}
-		go func() {
-			wg.Wait()
-		}()
+		wg.Wait()
+		return
+	}
 
+	// ordered: all events of a source directory are handled by the same worker, in order of arrival
+	queues := make([]chan EventInfo, n)
+	wg.Add(n)
+	for i := range queues {
+		queues[i] = make(chan EventInfo, 64) // buffer against a busy worker blocking all others
+		go worker(queues[i])
+	}
+	for e := range in {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(o.sourceDir(e.Event.AbsLoc, pi)))
+		queues[h.Sum32()%uint32(n)] <- e
+	}
+	for _, q := range queues {
+		close(q)
 	}
+	wg.Wait()
 }
 
 //!-stage-2
 
 //!+stage-1
 
+// enqueue records a new event in the journal and hands it over to stage-2, it is tracked
+// until its final result. Events of files which are already pending are dropped.
+func (o *FsEventOps) enqueue(e EventInfo, pi *EventPushInfo, out chan<- EventInfo, lg *logrus.Logger) {
+	if pi.stopping() {
+		// left on disk for the backfill of the next run
+		lg.WithField("stage", 1).Debugf("stopping, skipped %s", e.Event.AbsLoc)
+		return
+	}
+	ok, err := pi.Queue.Add(e)
+	if err != nil {
+		lg.WithField("stage", 1).Errorf("journal %s, %s", e.Event.AbsLoc, err)
+	} else if !ok {
+		lg.WithField("stage", 1).Debugf("already pending %s", e.Event.AbsLoc)
+		return
+	}
+	pi.inflight.add(1)
+	out <- e
+}
+
+// replay hands all events left unfinished by a previous run over to stage-2
+func (o *FsEventOps) replay(pi *EventPushInfo, out chan<- EventInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithFields(logrus.Fields{"stage": 1})
+	for _, e := range pi.Queue.Pending() {
+		if pi.stopping() {
+			return
+		}
+		if _, err := o.FsInfo(e.Event.AbsLoc); os.IsNotExist(err) {
+			if err := pi.Queue.Done(e); err != nil {
+				ctxLog.Errorf("journal %s", err)
+			}
+			continue
+		}
+		ctxLog.Infof("replay %s", e.Event.AbsLoc)
+		pi.inflight.add(1)
+		out <- e
+	}
+}
+
+// backfill sends all files found in the source directory to the stage-1 channel as if they had just been closed
+func (o *FsEventOps) backfill(root string, pi *EventPushInfo, out chan<- EventInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithFields(logrus.Fields{"stage": 1})
+	recursive := false
+	if u := o.owner(root, pi); u != nil {
+		recursive = u.Recursive
+	}
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() {
+			if p != root && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// empty files are still being created, only control files may be empty
+		if !fi.Mode().IsRegular() || (fi.Size() == 0 && !isControl(p, o.owner(p, pi))) {
+			return nil
+		}
+		fsEv := &FsEvent{
+			Event: fsnotify.Event{Name: p, Op: fsnotify.CloseWrite},
+			Ops:   o,
+		}
+		ev, err := fsEv.Info()
+		if err != nil {
+			ctxLog.Warnf("backfill %s", err)
+			return nil
+		}
+		// files younger than MinAge might still be written to, check again once old enough
+		if age := time.Since(fi.ModTime()); age < pi.MinAge {
+			ctxLog.Debugf("backfill deferred %s, age %s", p, age)
+			time.AfterFunc(pi.MinAge-age, func() { o.settled(*ev, pi, out, lg) })
+			return nil
+		}
+		ctxLog.Debugf("backfill %s", p)
+		o.enqueue(*ev, pi, out, lg)
+		return nil
+	})
+	if err != nil {
+		ctxLog.Errorf("backfill %s, %s", root, err)
+	}
+}
+
+// settled sends the event to the stage-1 channel only if its file did not change since the event was taken
+func (o *FsEventOps) settled(e EventInfo, pi *EventPushInfo, out chan<- EventInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithFields(logrus.Fields{"stage": 1})
+	fi, err := o.FsInfo(e.Event.AbsLoc)
+	if err != nil {
+		ctxLog.Debugf("settled %s", err)
+		return
+	}
+	if changed(fi, e) {
+		// still written to, the CloseWrite event will pick it up
+		ctxLog.Debugf("settled %s changed, skipping", e.Event.AbsLoc)
+		return
+	}
+	o.enqueue(e, pi, out, lg)
+}
+
+// changed reports whether the file differs in size or modification time from its event
+func changed(fi os.FileInfo, e EventInfo) bool {
+	return fi.Size() != e.Meta.Size || !fi.ModTime().Truncate(time.Millisecond).Equal(e.Meta.ModTime)
+}
+
 // Listen listens to file events from fsnotify.Watcher and sends them to the stage-1 channel
-func (o *FsEventOps) listen(w *fsnotify.Watcher, out chan<- EventInfo, lg *logrus.Logger) {
+func (o *FsEventOps) listen(t *watchTree, pi *EventPushInfo, out chan<- EventInfo, lg *logrus.Logger) {
 	ctxLog := lg.WithFields(logrus.Fields{"stage": 1})
 	for {
 		select {
-		case event := <-w.Events: // RECEIVE event
+		case event, ok := <-t.watcher.Events: // RECEIVE event
+			if !ok {
+				return // watcher closed, stop accepting new events
+			}
 			// all events are logged by default
 			ctxLog.Debugf("%v, eventT: %T", event, event)
 
+			// keep the watchTree of recursive users in sync with their source directories
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if fi, err := o.FsInfo(event.Name); err == nil && fi.IsDir() {
+					if u := o.owner(event.Name, pi); u != nil && u.Recursive {
+						if err := o.addTree(t, event.Name, lg); err != nil {
+							ctxLog.Errorf("addTree %s", err)
+						}
+						// files moved in along with the directory never see a CloseWrite
+						go o.backfill(event.Name, pi, out, lg)
+					}
+				}
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				for _, d := range t.remove(event.Name) {
+					ctxLog.Debugf("stopped watching %s", d)
+				}
+			}
+
 			if event.Op&fsnotify.CloseWrite == fsnotify.CloseWrite {
 				fsEv := &FsEvent{
 					Event: event,
@@ -168,23 +498,27 @@ func (o *FsEventOps) listen(w *fsnotify.Watcher, out chan<- EventInfo, lg *logru
 				ev, err := fsEv.Info()
 				if err != nil {
 					ctxLog.Warnf("Listen %s", err)
+					continue
 				}
 
-				// 32 bytes needed for determining file type
-				if ev.Meta.Size >= int64(32) {
-					out <- *ev // SEND needs no close as infinite amount of Events
+				// empty files are still being created, only control files may be empty
+				if ev.Meta.Size > 0 || isControl(ev.Event.AbsLoc, o.owner(ev.Event.AbsLoc, pi)) {
+					o.enqueue(*ev, pi, out, lg) // SEND needs no close as infinite amount of Events
 				} else {
 					// only for testing
 					einfo, err := json.Marshal(ev)
 					if err != nil {
 						ctxLog.Errorf("Json, %s", err)
 					}
-					ctxLog.Debugf("Unknown File Type, %v, eiT: %T", string(einfo), ev)
+					ctxLog.Debugf("Empty file, %v, eiT: %T", string(einfo), ev)
 				}
 
 			}
-		case err := <-w.Errors: // RECEIVE eventError
+		case err, ok := <-t.watcher.Errors: // RECEIVE eventError
 			// check if channel is closed (!ok == closed)
+			if !ok {
+				return
+			}
 			ctxLog.Errorf("Listen %s", err)
 		}
 	}
@@ -0,0 +1,119 @@
+package event
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// KeyData is the data a key template is rendered from
+type KeyData struct {
+	User        string    // name of the user owning the source directory
+	Dir         string    // directory relative to <userpath>/<user>, empty for its root
+	Name        string    // base name without compression suffix, e.g. export.csv
+	Ext         string    // extension of Name, e.g. .csv
+	ModTime     time.Time // modification time of the source file
+	Size        int64     // size of the source file in bytes
+	ContentType string    // detected MIME type of the (decompressed) content
+	Path        string    // absolute path of the source file
+}
+
+// Hash returns the hex encoded SHA-256 of the source file as received, it is only computed
+// if a template uses it
+func (d KeyData) Hash() (string, error) {
+	f, err := os.Open(d.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParseKeyTemplate parses a text/template rendering S3 keys from KeyData,
+// e.g. {{.User}}/dt={{.ModTime.Format "2006-01-02"}}/{{.Name}}
+func ParseKeyTemplate(text string) (*template.Template, error) {
+	t, err := template.New("key").Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "key template")
+	}
+	return t, nil
+}
+
+// RenderKey renders the S3 key of a file, empty path segments like those of an empty Dir are
+// dropped and the key must neither be empty nor contain "." or ".." segments
+func RenderKey(t *template.Template, d KeyData) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, d); err != nil {
+		return "", errors.Wrap(err, "key template")
+	}
+	segs := make([]string, 0)
+	for _, s := range strings.Split(b.String(), "/") {
+		switch s {
+		case "":
+			continue
+		case ".", "..":
+			return "", errors.Errorf("key template rendered invalid key %q", b.String())
+		}
+		segs = append(segs, s)
+	}
+	if len(segs) == 0 {
+		return "", errors.Errorf("key template rendered empty key")
+	}
+	return strings.Join(segs, "/"), nil
+}
+
+// objectKey returns the S3 key of the job, rendered from the user key template if set and
+// prefixed with the user target prefix
+func (o *FsEventOps) objectKey(j pushJob, contentType string) (string, error) {
+	key, err := o.renderKey(j, contentType)
+	if err != nil {
+		return "", err
+	}
+	if p := strings.Trim(j.user.Target.Prefix, "/"); p != "" {
+		key = p + "/" + key
+	}
+	return key, nil
+}
+
+// renderKey renders the user key template, it keeps the local layout if there is none
+func (o *FsEventOps) renderKey(j pushJob, contentType string) (string, error) {
+	if j.user.Key == nil {
+		return j.source, nil
+	}
+	return RenderKey(j.user.Key, j.keyData(contentType))
+}
+
+// keyData returns the template data of the job, taken from the source file rather than the
+// object key, which may be rendered, prefixed and carry an output compression suffix
+func (j *pushJob) keyData(contentType string) KeyData {
+	dir := path.Dir(j.source)
+	switch dir {
+	case ".", j.user.Name:
+		dir = ""
+	default:
+		dir = strings.TrimPrefix(dir, j.user.Name+"/")
+	}
+	name := path.Base(j.source)
+	return KeyData{
+		User:        j.user.Name,
+		Dir:         dir,
+		Name:        name,
+		Ext:         path.Ext(name),
+		ModTime:     j.event.Meta.ModTime,
+		Size:        j.event.Meta.Size,
+		ContentType: mediaType(contentType),
+		Path:        j.event.Event.AbsLoc,
+	}
+}
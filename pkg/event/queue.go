@@ -0,0 +1,188 @@
+package event
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// compactAfter is the number of journal lines after which done entries are dropped from the file
+const compactAfter = 10000
+
+// Journal is an append-only log of all events accepted by stage-1. Each event is recorded
+// when accepted and marked done once its file left the source directory, so unfinished
+// events can be replayed after a crash. A nil *Journal is valid and records nothing.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	lines   int
+	pending map[string]EventInfo // keyed by EventInfo.Event.AbsLoc
+}
+
+// journalEntry is a single line of the journal file
+type journalEntry struct {
+	Op    string    `json:"op"` // add | done
+	Event EventInfo `json:"event"`
+}
+
+// OpenJournal replays the journal file at path, creating it if needed, and compacts it
+// down to the events which are still pending
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{path: path, pending: make(map[string]EventInfo)}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(err, "OpenJournal")
+	}
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 1024*1024)
+		for s.Scan() {
+			var e journalEntry
+			// a crash may leave a truncated last line behind
+			if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+				continue
+			}
+			switch e.Op {
+			case "add":
+				j.pending[e.Event.Event.AbsLoc] = e.Event
+			case "done":
+				delete(j.pending, e.Event.Event.AbsLoc)
+			}
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return nil, errors.Wrapf(err, "OpenJournal %s", path)
+		}
+	case !os.IsNotExist(err):
+		return nil, errors.Wrap(err, "OpenJournal")
+	}
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Pending returns all events without done entry, in order of their modification time
+func (j *Journal) Pending() []EventInfo {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	res := make([]EventInfo, 0, len(j.pending))
+	for _, e := range j.pending {
+		res = append(res, e)
+	}
+	sort.Slice(res, func(a, b int) bool { return res[a].Meta.ModTime.Before(res[b].Meta.ModTime) })
+	return res
+}
+
+// Add records the event, it returns false if an event for the same file is already pending
+func (j *Journal) Add(e EventInfo) (bool, error) {
+	if j == nil {
+		return true, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[e.Event.AbsLoc]; ok {
+		return false, nil
+	}
+	if err := j.write(journalEntry{Op: "add", Event: e}); err != nil {
+		return false, err
+	}
+	j.pending[e.Event.AbsLoc] = e
+	return true, nil
+}
+
+// Done marks the event of the file as complete
+func (j *Journal) Done(e EventInfo) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.pending[e.Event.AbsLoc]; !ok {
+		return nil
+	}
+	if err := j.write(journalEntry{Op: "done", Event: e}); err != nil {
+		return err
+	}
+	delete(j.pending, e.Event.AbsLoc)
+	if j.lines > compactAfter && j.lines > 4*len(j.pending) {
+		return j.compact()
+	}
+	return nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.f.Close()
+}
+
+// write appends a single entry and syncs it to disk
+func (j *Journal) write(e journalEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.Wrap(err, "journal")
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return errors.Wrap(err, "journal")
+	}
+	j.lines++
+	return errors.Wrap(j.f.Sync(), "journal")
+}
+
+// compact rewrites the journal file with the pending events only
+func (j *Journal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "compact journal")
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range j.pending {
+		b, err := json.Marshal(journalEntry{Op: "add", Event: e})
+		if err != nil {
+			f.Close()
+			return errors.Wrap(err, "compact journal")
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			f.Close()
+			return errors.Wrap(err, "compact journal")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "compact journal")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "compact journal")
+	}
+	f.Close()
+	if err := os.Rename(tmp, j.path); err != nil {
+		return errors.Wrap(err, "compact journal")
+	}
+
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "compact journal")
+	}
+	j.lines = len(j.pending)
+	return nil
+}
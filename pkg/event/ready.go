@@ -0,0 +1,176 @@
+package event
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// nameVar stands for the base name of the marked file in file level marker patterns
+const nameVar = "{name}"
+
+// Ready controls the readiness markers of a user. With markers, files are only pushed once
+// marked ready, CloseWrite events of files not marked yet are dropped.
+type Ready struct {
+	// Markers containing {name} mark a single file, e.g. {name}.done marks data.csv with
+	// data.csv.done. Others are glob patterns of directory level markers releasing all files
+	// of their directory, e.g. _SUCCESS.
+	Markers []string
+}
+
+// Validate reports invalid marker patterns, a file level marker must differ from its file
+func (r Ready) Validate() error {
+	for _, m := range r.Markers {
+		if m == nameVar || strings.Count(m, nameVar) > 1 || strings.ContainsRune(m, filepath.Separator) {
+			return errors.Errorf("invalid marker %q", m)
+		}
+		if _, err := filepath.Match(strings.Replace(m, nameVar, "", 1), ""); err != nil {
+			return errors.Wrapf(err, "marker %q", m)
+		}
+	}
+	return nil
+}
+
+// enabled reports whether files wait for a marker
+func (r Ready) enabled() bool {
+	return len(r.Markers) > 0
+}
+
+// isMarker reports whether p is a readiness marker, it returns the marked file of a file
+// level marker and an empty path for a directory level marker
+func (r Ready) isMarker(p string) (string, bool) {
+	b := filepath.Base(p)
+	for _, m := range r.Markers {
+		if i := strings.Index(m, nameVar); i >= 0 {
+			pre, suf := m[:i], m[i+len(nameVar):]
+			if len(b) > len(pre)+len(suf) && strings.HasPrefix(b, pre) && strings.HasSuffix(b, suf) {
+				return filepath.Join(filepath.Dir(p), b[len(pre):len(b)-len(suf)]), true
+			}
+			continue
+		}
+		if ok, _ := filepath.Match(m, b); ok {
+			return "", true
+		}
+	}
+	return "", false
+}
+
+// markers returns the existing markers releasing p, file level ones first
+func (r Ready) markers(p string) []string {
+	res := make([]string, 0)
+	dir, b := filepath.Split(p)
+	for _, m := range r.Markers {
+		if strings.Contains(m, nameVar) {
+			if mp := filepath.Join(dir, strings.Replace(m, nameVar, b, 1)); isFile(mp) {
+				res = append(res, mp)
+			}
+		}
+	}
+	for _, m := range r.Markers {
+		if strings.Contains(m, nameVar) {
+			continue
+		}
+		ms, _ := filepath.Glob(filepath.Join(dir, m))
+		for _, mp := range ms {
+			if isFile(mp) {
+				res = append(res, mp)
+			}
+		}
+	}
+	return res
+}
+
+// isControl reports whether p is a readiness marker or checksum sidecar of the user, control
+// files are handled by the gate whatever their size
+func isControl(p string, u *UserInfo) bool {
+	if u == nil {
+		return false
+	}
+	_, ok := u.Ready.isMarker(p)
+	return ok || isSidecar(p, u)
+}
+
+// isFile reports whether p is a regular file
+func isFile(p string) bool {
+	fi, err := os.Stat(p)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+//!+stage-1
+
+// release returns the events of the files marked ready by the marker, the single marked file
+// of a file level marker or all files of the directory of a directory level marker
+func (o *FsEventOps) release(marker string, u *UserInfo, lg *logrus.Logger) []EventInfo {
+	ctxLog := lg.WithField("stage", 1)
+	paths := make([]string, 0)
+	if p, _ := u.Ready.isMarker(marker); p != "" {
+		paths = append(paths, p)
+	} else {
+		fis, err := ioutil.ReadDir(filepath.Dir(marker))
+		if err != nil {
+			ctxLog.Errorf("release %s", err)
+			return nil
+		}
+		for _, fi := range fis {
+			p := filepath.Join(filepath.Dir(marker), fi.Name())
+			if fi.Mode().IsRegular() && !isControl(p, u) {
+				paths = append(paths, p)
+			}
+		}
+	}
+
+	res := make([]EventInfo, 0, len(paths))
+	for _, p := range paths {
+		fsEv := &FsEvent{
+			Event: fsnotify.Event{Name: p, Op: fsnotify.CloseWrite},
+			Ops:   o,
+		}
+		ev, err := fsEv.Info()
+		if err != nil {
+			if !os.IsNotExist(err) {
+				ctxLog.Warnf("release %s", err)
+			}
+			continue
+		}
+		res = append(res, *ev)
+	}
+	return res
+}
+
+//!-stage-1
+
+// clearMarkers removes the readiness markers of a pushed file. A directory level marker is
+// only removed once the last file of its directory is gone.
+func clearMarkers(p string, u *UserInfo) error {
+	if u == nil || !u.Ready.enabled() {
+		return nil
+	}
+	for _, m := range u.Ready.markers(p) {
+		if mp, _ := u.Ready.isMarker(m); mp == "" && pendingFiles(filepath.Dir(m), u) {
+			continue
+		}
+		if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingFiles reports whether dir still holds files to push
+func pendingFiles(dir string, u *UserInfo) bool {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, fi := range fis {
+		if fi.Mode().IsRegular() && !isControl(filepath.Join(dir, fi.Name()), u) {
+			return true
+		}
+	}
+	return false
+}
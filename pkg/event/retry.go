@@ -0,0 +1,283 @@
+package event
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// deadLetterExt is the suffix of the JSON sidecar stored next to each dead-lettered file
+	deadLetterExt = ".deadletter.json"
+	// quarantineExt is the suffix of the JSON sidecar stored next to each quarantined file
+	quarantineExt = ".quarantine.json"
+)
+
+//!+stage-4
+
+// results consumes the upload results and reschedules failed uploads
+func (o *FsEventOps) results(in chan<- EventInfo, pi *EventPushInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithField("stage", 4)
+	for f := range pi.Results {
+		switch f.status {
+		case Uploaded:
+			ctxLog.Debugf("INFO[+] Results: %#v", f)
+			// the removal policy may accept failed destinations
+			for _, d := range f.destinations {
+				if d.status != Uploaded {
+					ctxLog.Warnf("Results: %s %s at %s, %v", d.status, f.eventInfo.Event.AbsLoc, d.dest, d.err)
+				}
+			}
+			pi.inflight.done()
+			continue
+		case Quarantined:
+			ctxLog.Warnf("Results: %s %s, %v", f.status, f.eventInfo.Event.AbsLoc, f.err)
+			if err := pi.Queue.Done(f.eventInfo); err != nil {
+				ctxLog.Errorf("journal %s", err)
+			}
+			pi.inflight.done()
+			continue
+		case Changed:
+			ctxLog.Infof("Results: %s %s, pushing it again", f.status, f.eventInfo.Event.AbsLoc)
+			o.repush(f.eventInfo, in, pi, lg)
+			continue
+		}
+		ctxLog.Warnf("Results: %s %s, %v", f.status, f.eventInfo.Event.AbsLoc, f.err)
+		o.reschedule(f, in, pi, lg)
+	}
+}
+
+// repush sends a fresh event of a file rewritten during its upload back to stage-1, its
+// journal entry stays pending
+func (o *FsEventOps) repush(e EventInfo, in chan<- EventInfo, pi *EventPushInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithField("stage", 4)
+	fsEv := &FsEvent{
+		Event: fsnotify.Event{Name: e.Event.AbsLoc, Op: fsnotify.CloseWrite},
+		Ops:   o,
+	}
+	ev, err := fsEv.Info()
+	if err != nil {
+		ctxLog.Warnf("repush %s, dropping event", err)
+		if err := pi.Queue.Done(e); err != nil {
+			ctxLog.Errorf("journal %s", err)
+		}
+		pi.inflight.done()
+		return
+	}
+	// sent from a timer, results must not block on stage-1
+	if !pi.after(0, func() { in <- *ev }) {
+		// stopping, the journal keeps the event for the next run
+		pi.inflight.done()
+	}
+}
+
+// reschedule sends a failed event back to stage-2 after a backoff, or moves it to the
+// dead-letter directory of its user once all attempts are used up
+func (o *FsEventOps) reschedule(r *ResultInfo, in chan<- EventInfo, pi *EventPushInfo, lg *logrus.Logger) {
+	ctxLog := lg.WithField("stage", 4)
+	e := r.eventInfo
+	if _, err := o.FsInfo(e.Event.AbsLoc); os.IsNotExist(err) {
+		ctxLog.Warnf("reschedule %s vanished, dropping event", e.Event.AbsLoc)
+		if err := pi.Queue.Done(e); err != nil {
+			ctxLog.Errorf("journal %s", err)
+		}
+		pi.inflight.done()
+		return
+	}
+	if e.Event.Attempt+1 < pi.Retry.Attempts {
+		e.Event.Attempt++
+		d := pi.Retry.Delay(e.Event.Attempt)
+		if !pi.after(d, func() { in <- e }) {
+			// stopping, the journal keeps the event for the next run
+			pi.inflight.done()
+			return
+		}
+		ctxLog.Infof("retry %s in %s, attempt %d/%d", e.Event.AbsLoc, d, e.Event.Attempt+1, pi.Retry.Attempts)
+		return
+	}
+	if err := o.deadLetter(e, r.err, pi); err != nil {
+		ctxLog.Errorf("deadLetter %s", err)
+	} else if err := pi.Queue.Done(e); err != nil {
+		ctxLog.Errorf("journal %s", err)
+	}
+	pi.inflight.done()
+}
+
+//!-stage-4
+
+// Delay returns the exponential backoff before the given attempt with equal jitter applied,
+// a MaxBackoff of zero does not cap it
+func (r Retry) Delay(attempt int) time.Duration {
+	d := r.Backoff
+	for i := 1; i < attempt && (r.MaxBackoff <= 0 || d < r.MaxBackoff) && d < math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if r.MaxBackoff > 0 && d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// deadLetter moves the event file into the dead-letter directory of its user and stores
+// the EventInfo along with the last error in a JSON sidecar
+func (o *FsEventOps) deadLetter(e EventInfo, cause error, pi *EventPushInfo) error {
+	u := o.owner(e.Event.AbsLoc, pi)
+	if u == nil || u.DeadLetter == "" {
+		return errors.Errorf("no dead-letter directory for %s", e.Event.AbsLoc)
+	}
+	return o.moveAside(e, u.DeadLetter, deadLetterExt, cause, pi)
+}
+
+// quarantine moves the event file of a rejected type into the quarantine directory of its user,
+// quarantined files are not picked up by Retry
+func (o *FsEventOps) quarantine(e EventInfo, cause error, pi *EventPushInfo) error {
+	u := o.owner(e.Event.AbsLoc, pi)
+	if u == nil || u.Quarantine == "" {
+		return errors.Errorf("no quarantine directory for %s", e.Event.AbsLoc)
+	}
+	return o.moveAside(e, u.Quarantine, quarantineExt, cause, pi)
+}
+
+// moveAside moves the event file below dir and stores the EventInfo along with the error in
+// a JSON sidecar with the given extension
+func (o *FsEventOps) moveAside(e EventInfo, dir string, ext string, cause error, pi *EventPushInfo) error {
+	u := o.owner(e.Event.AbsLoc, pi)
+	if u == nil {
+		return errors.Errorf("no user for %s", e.Event.AbsLoc)
+	}
+	// keep the layout below <userpath>/<user> so equally named files do not collide
+	rel, err := filepath.Rel(filepath.Join(*pi.Userpath, u.Name), e.Event.AbsLoc)
+	if err != nil {
+		return errors.Wrapf(err, "moveAside %s", e.Event.AbsLoc)
+	}
+	dst := filepath.Join(dir, rel)
+
+	msg := ""
+	if cause != nil {
+		msg = cause.Error()
+	}
+	b, err := json.MarshalIndent(DeadLetter{EventInfo: e, Error: msg, Time: time.Now()}, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "moveAside %s", e.Event.AbsLoc)
+	}
+	if err := moveF(e.Event.AbsLoc, dst); err != nil {
+		return errors.Wrapf(err, "moveAside %s", e.Event.AbsLoc)
+	}
+	return ioutil.WriteFile(dst+ext, b, 0644)
+}
+
+// moveF renames src to dst, falls back to copy and remove across file systems
+func moveF(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// restore moves a dead-lettered file back to its source location and returns its fresh EventInfo
+func (o *FsEventOps) restore(p string) (*EventInfo, error) {
+	b, err := ioutil.ReadFile(p + deadLetterExt)
+	if err != nil {
+		return nil, err
+	}
+	var dl DeadLetter
+	if err := json.Unmarshal(b, &dl); err != nil {
+		return nil, errors.Wrapf(err, "sidecar %s", p+deadLetterExt)
+	}
+	dst := dl.EventInfo.Event.AbsLoc
+	if _, err := o.FsInfo(dst); err == nil {
+		return nil, errors.Errorf("restore %s, a file with the same name arrived since", dst)
+	}
+	if err := moveF(p, dst); err != nil {
+		return nil, err
+	}
+	if err := os.Remove(p + deadLetterExt); err != nil {
+		return nil, err
+	}
+	fsEv := &FsEvent{
+		Event: fsnotify.Event{Name: dst, Op: fsnotify.CloseWrite},
+		Ops:   o,
+	}
+	return fsEv.Info()
+}
+
+// Retry moves all dead-lettered files back to their source directories and pushes them once more
+func (o *FsEventOps) Retry(epIn *EventPushInfo, lg *logrus.Logger) error {
+	ctxLog := lg.WithField("stage", 0)
+	in := make(chan EventInfo)
+	gated := make(chan EventInfo)
+	go o.gate(in, gated, true, epIn, lg)
+	go o.controlWorkers(gated, epIn, lg)
+	go o.results(in, epIn, lg)
+
+	seen := make(map[string]bool)
+	for _, u := range epIn.Users {
+		if u.DeadLetter == "" || seen[u.DeadLetter] {
+			continue
+		}
+		seen[u.DeadLetter] = true
+		err := filepath.Walk(u.DeadLetter, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			// payloads are all files which come with a sidecar
+			if fi.IsDir() {
+				return nil
+			}
+			if _, err := os.Stat(p + deadLetterExt); err != nil {
+				return nil
+			}
+			ev, err := o.restore(p)
+			if err != nil {
+				ctxLog.Warnf("Retry %s", err)
+				return nil
+			}
+			ctxLog.Infof("Retry %s", ev.Event.AbsLoc)
+			o.enqueue(*ev, epIn, in, lg)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "Retry %s", u.DeadLetter)
+		}
+	}
+	epIn.inflight.wait(-1)
+	close(in)
+	return nil
+}
@@ -0,0 +1,106 @@
+package event
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrAbandoned is returned by NewWatcher if events were left unfinished after the grace period
+var ErrAbandoned = errors.New("events abandoned")
+
+// counter keeps track of the events handed to stage-2 without a final result yet
+type counter struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{} // closed once n drops to zero
+}
+
+func (c *counter) add(d int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n == 0 {
+		c.idle = make(chan struct{})
+	}
+	c.n += d
+}
+
+func (c *counter) done() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n--
+	if c.n == 0 {
+		close(c.idle)
+	}
+}
+
+// wait blocks until all events are finished or the timeout expired, a negative timeout waits
+// forever and zero not at all. It returns the number of unfinished events.
+func (c *counter) wait(timeout time.Duration) int {
+	c.mu.Lock()
+	if c.n == 0 {
+		c.mu.Unlock()
+		return 0
+	}
+	idle := c.idle
+	c.mu.Unlock()
+
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	select {
+	case <-idle:
+	case <-expired:
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// stopping reports whether the pipeline stopped accepting new events
+func (pi *EventPushInfo) stopping() bool {
+	select {
+	case <-pi.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// after runs f once the retry delay d passed, unless the pipeline stops before.
+// It returns false if the pipeline is already stopping.
+func (pi *EventPushInfo) after(d time.Duration, f func()) bool {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	if pi.stopping() {
+		return false
+	}
+	if pi.timers == nil {
+		pi.timers = make(map[*time.Timer]bool)
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		pi.mu.Lock()
+		delete(pi.timers, t)
+		pi.mu.Unlock()
+		f()
+	})
+	pi.timers[t] = true
+	return true
+}
+
+// stopRetries cancels all pending retries, their events stay in the journal for the next run
+func (pi *EventPushInfo) stopRetries() {
+	pi.mu.Lock()
+	defer pi.mu.Unlock()
+	for t := range pi.timers {
+		if t.Stop() {
+			pi.inflight.done()
+		}
+		delete(pi.timers, t)
+	}
+}
@@ -0,0 +1,136 @@
+package event
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// sidecarHashes are the extensions of checksum sidecars along with their hash
+var sidecarHashes = map[string]func() hash.Hash{
+	".md5":    md5.New,
+	".sha256": sha256.New,
+}
+
+// Payloads are quarantined for lack of a required checksum sidecar, or if it holds no digest
+var (
+	errNoSidecar  = errors.New("no checksum sidecar")
+	errBadSidecar = errors.New("invalid checksum sidecar")
+)
+
+// Sidecar controls the checksum files clients upload along with their payload, e.g.
+// data.csv.gz.sha256 for data.csv.gz, in the format of md5sum or sha256sum. With Verify all
+// .md5 and .sha256 files of the user are taken for sidecars and never uploaded on their own.
+type Sidecar struct {
+	Verify  bool          // verify payloads against their sidecar before the upload, mismatches are quarantined
+	Wait    time.Duration // time a payload waits for its sidecar to arrive
+	Require bool          // quarantine payloads still without sidecar once Wait expired
+	Upload  bool          // upload the sidecar next to the object, under its original name
+}
+
+// pushSidecar uploads the checksum sidecar of a pushed payload if configured, it is stored
+// next to the object under its original name and removed along with the event file
+func (o *FsEventOps) pushSidecar(done <-chan struct{}, j pushJob, res *ResultInfo, pi *EventPushInfo, lg *logrus.Logger) *ResultInfo {
+	if j.sidecar == "" || res.status != Uploaded {
+		return res
+	}
+	res.sidecar = j.sidecar
+	if !j.user.Sidecar.Upload {
+		return res
+	}
+	f, err := os.Open(j.sidecar)
+	if err != nil {
+		return &ResultInfo{eventInfo: j.event, keys: res.keys, status: Failed, err: errors.Wrap(err, "sidecar")}
+	}
+	defer f.Close()
+	j.key = path.Join(path.Dir(j.key), filepath.Base(j.sidecar))
+	j.body = f
+	j.contentType = "text/plain; charset=utf-8"
+	j.metadata = nil
+	j.raw = true
+	r := o.push(done, j, pi, lg)
+	if r.status != Uploaded {
+		r.keys = res.keys
+		return r
+	}
+	res.keys = append(res.keys, j.key)
+	return res
+}
+
+// isSidecar reports whether p is a checksum sidecar the user verifies payloads with
+func isSidecar(p string, u *UserInfo) bool {
+	if u == nil || !u.Sidecar.Verify {
+		return false
+	}
+	_, ok := sidecarHashes[filepath.Ext(p)]
+	return ok
+}
+
+// findSidecar returns the path of the checksum sidecar of the payload, empty if there is none.
+// A SHA-256 sidecar is preferred over an MD5 one.
+func findSidecar(p string) string {
+	for _, ext := range []string{".sha256", ".md5"} {
+		if fi, err := os.Stat(p + ext); err == nil && fi.Mode().IsRegular() {
+			return p + ext
+		}
+	}
+	return ""
+}
+
+// checkSidecar verifies the payload against its checksum sidecar and returns the sidecar path.
+// Without sidecar it returns an empty path, or errNoSidecar if the user requires one.
+func checkSidecar(p string, u *UserInfo) (string, error) {
+	sc := findSidecar(p)
+	if sc == "" {
+		if u.Sidecar.Require {
+			return "", errors.Wrapf(errNoSidecar, "%s after %s", filepath.Base(p), u.Sidecar.Wait)
+		}
+		return "", nil
+	}
+	exp, err := readSidecar(sc)
+	if err != nil {
+		return sc, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return sc, err
+	}
+	defer f.Close()
+	h := sidecarHashes[filepath.Ext(sc)]()
+	if _, err := io.Copy(h, f); err != nil {
+		return sc, err
+	}
+	if act := hex.EncodeToString(h.Sum(nil)); act != exp {
+		return sc, errors.Wrapf(errChecksumMismatch, "%s is %s, sidecar %s", filepath.Base(p), act, exp)
+	}
+	return sc, nil
+}
+
+// readSidecar returns the lower case hex digest of a sidecar, either the bare digest or the
+// first line of md5sum or sha256sum output
+func readSidecar(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) == 0 {
+		return "", errors.Wrapf(errBadSidecar, "%s is empty", filepath.Base(p))
+	}
+	d := strings.ToLower(fields[0])
+	if b, err := hex.DecodeString(d); err != nil || len(b) != sidecarHashes[filepath.Ext(p)]().Size() {
+		return "", errors.Wrapf(errBadSidecar, "%s holds no valid digest", filepath.Base(p))
+	}
+	return d, nil
+}
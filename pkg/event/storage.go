@@ -0,0 +1,134 @@
+package event
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	version "github.com/olmax99/sftppush/internal/version"
+	"github.com/pkg/errors"
+)
+
+// storageClasses are the S3 storage classes objects may be written to
+var storageClasses = []string{
+	"STANDARD", "REDUCED_REDUNDANCY", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING",
+	"GLACIER", "GLACIER_IR", "DEEP_ARCHIVE", "OUTPOSTS",
+}
+
+// S3 limits of object tags
+const (
+	maxTags        = 10
+	maxTagKeyLen   = 128
+	maxTagValueLen = 256
+)
+
+// Storage controls how S3 stores the objects of a user, destinations other than S3 ignore it
+type Storage struct {
+	Class    string                        // storage class, empty for the bucket default
+	ACL      string                        // canned ACL, e.g. bucket-owner-full-control
+	Tags     map[string]*template.Template // object tag values rendered from KeyData
+	Metadata bool                          // add mtime, size, mode and path of the source file
+}
+
+// Validate reports storage settings S3 would reject
+func (s Storage) Validate() error {
+	if s.Class != "" && !contains(storageClasses, s.Class) {
+		return errors.Errorf("storage class %q, want one of %s", s.Class, strings.Join(storageClasses, ", "))
+	}
+	if s.ACL != "" && !contains(s3.ObjectCannedACL_Values(), s.ACL) {
+		return errors.Errorf("acl %q, want one of %s", s.ACL, strings.Join(s3.ObjectCannedACL_Values(), ", "))
+	}
+	if len(s.Tags) > maxTags {
+		return errors.Errorf("%d tags, at most %d allowed", len(s.Tags), maxTags)
+	}
+	for k := range s.Tags {
+		if k == "" || len(k) > maxTagKeyLen || tagValue(k) != k {
+			return errors.Errorf("invalid tag key %q", k)
+		}
+	}
+	return nil
+}
+
+// ParseTagTemplates parses the tag values, static values are templates without actions,
+// e.g. {"tenant": "{{.User}}", "origin": "sftp"}
+func ParseTagTemplates(tags map[string]string) (map[string]*template.Template, error) {
+	res := make(map[string]*template.Template, len(tags))
+	for k, v := range tags {
+		t, err := template.New(k).Parse(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tag %s", k)
+		}
+		res[k] = t
+	}
+	return res, nil
+}
+
+// tags renders the object tags of the job, characters S3 does not allow in tags are replaced
+func (j *pushJob) tags() (map[string]string, error) {
+	if len(j.user.Storage.Tags) == 0 {
+		return nil, nil
+	}
+	d := j.keyData(j.contentType)
+	res := make(map[string]string, len(j.user.Storage.Tags))
+	for k, t := range j.user.Storage.Tags {
+		var b bytes.Buffer
+		if err := t.Execute(&b, d); err != nil {
+			return nil, errors.Wrapf(err, "tag %s", k)
+		}
+		v := tagValue(b.String())
+		if len(v) > maxTagValueLen {
+			v = v[:maxTagValueLen]
+		}
+		res[k] = v
+	}
+	return res, nil
+}
+
+// objectMetadata returns the user metadata of the job, with the source file metadata and
+// checksum added if configured. The source keys do not collide with those of archive entries.
+func (j *pushJob) objectMetadata() map[string]string {
+	if !j.user.Storage.Metadata && j.sourceSum == "" {
+		return j.metadata
+	}
+	res := make(map[string]string)
+	if j.user.Storage.Metadata {
+		m := j.event.Meta
+		res["Source-Mtime"] = m.ModTime.UTC().Format(time.RFC3339)
+		res["Source-Size"] = strconv.FormatInt(m.Size, 10)
+		res["Source-Mode"] = fmt.Sprintf("%04o", m.Mode.Perm())
+		res["Source-Path"] = j.event.Event.AbsLoc
+		res["Sftppush-Version"] = version.Version
+	}
+	if j.sourceSum != "" {
+		res["Source-Sha256"] = j.sourceSum
+	}
+	for k, v := range j.metadata {
+		res[k] = v
+	}
+	return res
+}
+
+// tagValue replaces the characters S3 does not allow in tags with an underscore
+func tagValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || strings.ContainsRune("+-=._:/@", r) {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// contains reports whether the list contains s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
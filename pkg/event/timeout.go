@@ -0,0 +1,86 @@
+package event
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// forSize returns the upload timeout of a source file with the given size, zero if unlimited
+func (t Timeout) forSize(size int64) time.Duration {
+	if t.Base == 0 && t.PerMB == 0 {
+		return 0
+	}
+	mb := (size + 1<<20 - 1) >> 20
+	return t.Base + time.Duration(mb)*t.PerMB
+}
+
+// stallWatch cancels an upload once its request bodies stopped moving for the idle duration
+type stallWatch struct {
+	last    int64 // unix nano of the last progress, accessed atomically
+	stalled int32 // set to 1 once the watch fired, accessed atomically
+	idle    time.Duration
+}
+
+// newStallWatch starts watching the upload progress, cancel is called once the upload stalls
+func newStallWatch(ctx context.Context, idle time.Duration, cancel func()) *stallWatch {
+	s := &stallWatch{last: time.Now().UnixNano(), idle: idle}
+	go func() {
+		t := time.NewTicker(idle / 4)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				if now.Sub(time.Unix(0, atomic.LoadInt64(&s.last))) > idle {
+					atomic.StoreInt32(&s.stalled, 1)
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// isStalled reports whether the upload was canceled by the stallWatch
+func (s *stallWatch) isStalled() bool {
+	return atomic.LoadInt32(&s.stalled) == 1
+}
+
+// option wraps the HTTP body of every upload request, so bytes are counted when actually sent
+func (s *stallWatch) option() request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Send.PushFront(func(r *request.Request) {
+			if b := r.HTTPRequest.Body; b != nil && b != http.NoBody {
+				r.HTTPRequest.Body = &progressBody{ReadCloser: b, watch: s}
+			}
+		})
+	}
+}
+
+// reader returns r recording the time of each successful read, for destinations which do not
+// report their progress themselves
+func (s *stallWatch) reader(r io.Reader) io.Reader {
+	return &progressBody{ReadCloser: ioutil.NopCloser(r), watch: s}
+}
+
+// progressBody records the time of the last successful read on its stallWatch
+type progressBody struct {
+	io.ReadCloser
+	watch *stallWatch
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&b.watch.last, time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,42 @@
+package event
+
+import (
+	"mime"
+	"strings"
+)
+
+// allows reports whether files of the MIME type mt may be pushed
+func (t Types) allows(mt string) bool {
+	for _, p := range t.Deny {
+		if matchType(p, mt) {
+			return false
+		}
+	}
+	if len(t.Allow) == 0 {
+		return true
+	}
+	for _, p := range t.Allow {
+		if matchType(p, mt) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchType matches the MIME type mt against a full type or a "<type>/*" wildcard
+func matchType(pattern string, mt string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mt, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == mt
+}
+
+// mediaType strips the parameters like charset from a detected content type
+func mediaType(ct string) string {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return strings.ToLower(ct)
+	}
+	return mt
+}
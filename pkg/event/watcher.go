@@ -1,8 +1,10 @@
 package event
 
 import (
+	"context"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -33,9 +35,7 @@ func (o *FsEventOps) reduceEventPath(evp string, cfgp *string) (string, error) {
 		reducedPath = append([]string{eventPath[len(dEventPath)-1]}, reducedPath...)
 	}
 	res := strings.Join(reducedPath, "/")
-	res = strings.TrimSuffix(res, ".gzip")
-	res = strings.TrimSuffix(res, ".gz")
-	return res, nil
+	return trimSuffixes(res), nil
 }
 
 //  EventSrc returns the absolute source path of the triggered file event
@@ -82,8 +82,187 @@ func (e *FsEvent) Info() (*EventInfo, error) {
 	}, nil
 }
 
-// Implements fsnotify file event watcher on a target directory
-func (o *FsEventOps) NewWatcher(epIn *EventPushInfo, lg *logrus.Logger) {
+// sourceDir returns the configured source directory which contains the given path
+func (o *FsEventOps) sourceDir(p string, pi *EventPushInfo) string {
+	pi.cfg.RLock()
+	defer pi.cfg.RUnlock()
+	for d := filepath.Clean(p); ; d = filepath.Dir(d) {
+		if _, ok := pi.Users[d]; ok {
+			return d
+		}
+		if d == filepath.Dir(d) {
+			return ""
+		}
+	}
+}
+
+// owner returns the user whose source directory contains the given path
+func (o *FsEventOps) owner(p string, pi *EventPushInfo) *UserInfo {
+	d := o.sourceDir(p, pi)
+	pi.cfg.RLock()
+	defer pi.cfg.RUnlock()
+	return pi.Users[d]
+}
+
+// watchdirs returns a copy of the current source directories
+func (pi *EventPushInfo) watchdirs() []string {
+	pi.cfg.RLock()
+	defer pi.cfg.RUnlock()
+	return append([]string(nil), pi.Watchdirs...)
+}
+
+// addTree adds the root directory and all of its subdirectories to the watchTree
+func (o *FsEventOps) addTree(t *watchTree, root string, lg *logrus.Logger) error {
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			// directory vanished while walking, nothing left to watch
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if err := t.add(p); err != nil {
+			return errors.Wrapf(err, "watch %s", p)
+		}
+		lg.WithField("stage", 0).Debugf("watching %s", p)
+		return nil
+	})
+}
+
+// add starts watching a single directory
+func (t *watchTree) add(d string) error {
+	d = filepath.Clean(d)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dirs[d] {
+		return nil
+	}
+	if err := t.watcher.Add(d); err != nil {
+		return err
+	}
+	t.dirs[d] = true
+	return nil
+}
+
+// remove stops watching the directory d along with all of its subdirectories
+func (t *watchTree) remove(d string) []string {
+	d = filepath.Clean(d)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	removed := make([]string, 0)
+	for w := range t.dirs {
+		if w != d && !strings.HasPrefix(w, d+"/") {
+			continue
+		}
+		// inotify drops watches of deleted directories implicitly
+		_ = t.watcher.Remove(w)
+		delete(t.dirs, w)
+		removed = append(removed, w)
+	}
+	return removed
+}
+
+// watched returns a copy of the watched directories
+func (t *watchTree) watched() map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res := make(map[string]bool, len(t.dirs))
+	for d := range t.dirs {
+		res[d] = true
+	}
+	return res
+}
+
+// rollback stops watching the directories below the roots which were not watched in prev
+func (t *watchTree) rollback(prev map[string]bool, roots []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for w := range t.dirs {
+		if prev[w] {
+			continue
+		}
+		for _, d := range roots {
+			if w == d || strings.HasPrefix(w, d+"/") {
+				_ = t.watcher.Remove(w)
+				delete(t.dirs, w)
+				break
+			}
+		}
+	}
+}
+
+// Reload applies the source directories and per user settings of next to the running watcher.
+// Watches for new sources are added first, if that fails the running config stays in force.
+func (o *FsEventOps) Reload(epIn *EventPushInfo, next *EventPushInfo, lg *logrus.Logger) error {
+	ctxLog := lg.WithField("stage", 0)
+	epIn.cfg.RLock()
+	t, events := epIn.tree, epIn.events
+	old := epIn.Users
+	epIn.cfg.RUnlock()
+	if t == nil {
+		return errors.New("Reload: watcher not running")
+	}
+
+	// new sources and those switched to recursive, their watches are undone on failure
+	watched := t.watched()
+	touched := make([]string, 0)
+	added := make([]string, 0)
+	for _, d := range next.Watchdirs {
+		d = filepath.Clean(d)
+		u := next.Users[d]
+		prev, ok := old[d]
+		if ok && (prev.Recursive || !u.Recursive) {
+			continue
+		}
+		touched = append(touched, d)
+		var err error
+		if u.Recursive {
+			err = o.addTree(t, d, lg)
+		} else {
+			err = t.add(d)
+		}
+		if err != nil {
+			t.rollback(watched, touched)
+			return errors.Wrapf(err, "Reload.Add %s", d)
+		}
+		if !ok {
+			added = append(added, d)
+		}
+	}
+
+	epIn.cfg.Lock()
+	epIn.Watchdirs, epIn.Users = next.Watchdirs, next.Users
+	epIn.cfg.Unlock()
+
+	// drop watches which are no longer needed
+	for d, prev := range old {
+		u, ok := next.Users[d]
+		switch {
+		case !ok:
+			t.remove(d)
+			ctxLog.Infof("Reload: stopped watching %s", d)
+		case prev.Recursive && !u.Recursive:
+			t.remove(d)
+			if err := t.add(d); err != nil {
+				ctxLog.Errorf("Reload.Add %s, %s", d, err)
+			}
+		}
+	}
+	for _, d := range added {
+		ctxLog.Infof("Reload: watching %s", d)
+		if epIn.Backfill {
+			go o.backfill(d, epIn, events, lg)
+		}
+	}
+	return nil
+}
+
+// Implements fsnotify file event watcher on a target directory, it runs until ctx is done
+// and then waits up to epIn.Grace for the events in progress
+func (o *FsEventOps) NewWatcher(ctx context.Context, epIn *EventPushInfo, lg *logrus.Logger) error {
 	ctxLog := lg.WithField("stage", 0)
 
 	//!+stage-0
@@ -91,36 +270,72 @@ func (o *FsEventOps) NewWatcher(epIn *EventPushInfo, lg *logrus.Logger) {
 	// 2. runs the final stage <- receiving from all open channels
 	watcher, err := fsnotify.NewWatcher() // watcher: implements producer stage-0
 	if err != nil {
-		ctxLog.Fatal(err)
+		return errors.Wrap(err, "NewWatcher")
 	}
 	defer watcher.Close() // close SEND Channel
+	tree := &watchTree{watcher: watcher, dirs: make(map[string]bool)}
 
-	// Add directories to *Watcher
-	for _, d := range epIn.Watchdirs {
-		err = watcher.Add(d)
+	// Add directories to *Watcher, recursive users get their full source tree watched
+	for _, d := range epIn.watchdirs() {
+		if u := o.owner(d, epIn); u != nil && u.Recursive {
+			err = o.addTree(tree, d, lg)
+		} else {
+			err = tree.add(d)
+		}
 		if err != nil {
-			ctxLog.Fatalf("NewWatcher.Add %s, %s", d, err)
+			return errors.Wrapf(err, "NewWatcher.Add %s", d)
 		}
 	}
 	//!-stage-0
 
 	//!+stage-1
 	//!+stage-2
-	done := make(chan bool)
+	epIn.quit = make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		close(epIn.quit) // stop accepting new events
+		watcher.Close()  // ends listen
+		close(stopped)
+	}()
 
 	targetEvent := make(chan EventInfo)
 	// eventErr := make(chan errors)
 
-	go o.listen(watcher, targetEvent, lg) // fsnotify event implementation
-	go o.controlWorkers(targetEvent, epIn, lg)
+	// make the running watcher available to Reload
+	epIn.cfg.Lock()
+	epIn.tree, epIn.events = tree, targetEvent
+	epIn.cfg.Unlock()
 
-	// Wait for all results in the background
-	go func() {
-		for f := range epIn.Results {
-			lg.Debugf("INFO[+] Results: %#v", f)
+	// files wait to settle, and payloads expecting a checksum sidecar wait for it between stage-1 and stage-2
+	settled := make(chan EventInfo)
+	go o.debounce(targetEvent, settled, epIn, lg)
+	gated := make(chan EventInfo)
+	go o.gate(settled, gated, false, epIn, lg)
+	go o.controlWorkers(gated, epIn, lg)
+
+	// Wait for all results in the background, failed uploads are sent back to stage-2
+	go o.results(targetEvent, epIn, lg)
+
+	// Push files which were pending or arrived while sftppush was down before listening for new events
+	o.replay(epIn, targetEvent, lg)
+	if epIn.Backfill {
+		for _, d := range epIn.watchdirs() {
+			o.backfill(d, epIn, targetEvent, lg)
 		}
-	}()
-	<-done // Block for listen, controlWorkers to run
+	}
+	go o.listen(tree, epIn, targetEvent, lg) // fsnotify event implementation
+
+	<-stopped // Block for listen, controlWorkers to run
 	//!-stage-2
 	//!-stage-1
+
+	// Drain the uploads in progress, pending retries are left to the journal
+	epIn.stopRetries()
+	ctxLog.Infof("shutting down, waiting up to %s for events in progress", epIn.Grace)
+	if n := epIn.inflight.wait(epIn.Grace); n > 0 {
+		return errors.Wrapf(ErrAbandoned, "%d events after %s", n, epIn.Grace)
+	}
+	ctxLog.Infof("all events finished")
+	return nil
 }
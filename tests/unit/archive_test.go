@@ -0,0 +1,102 @@
+package sftppush
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that zip archives exceeding the uncompressed size limit are rejected before any upload
+func Test_ZipMaxSize(t *testing.T) {
+	p := newTestPipeline(t)
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	w, _ := zw.Create("dir/entry.txt")
+	w.Write([]byte(strings.Repeat("0", 4096)))
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed test setup: zip.Close .. %s", err)
+	}
+	p.write("bomb.zip", b.Bytes())
+	p.epi.Archive = event.Archive{SkipDirs: true, MaxSize: 1024}
+
+	p.watch("bomb.zip")
+	t.Run("Test zip exceeding MaxSize is dead-lettered", func(t *testing.T) {
+		act, err := p.deadLettered("bomb.zip")
+		if err != nil {
+			t.Fatalf("NewWatcher() sidecar => %s, want dead-lettered", err)
+		}
+		if !strings.Contains(act.Error, "exceeds 1024 bytes") {
+			t.Errorf("NewWatcher() sidecar error => %q, want size limit exceeded", act.Error)
+		}
+	})
+}
+
+// Ensure that tar streams are detected after decompression and checked against the size limit
+func Test_TarMaxSize(t *testing.T) {
+	p := newTestPipeline(t)
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gw)
+	body := []byte(strings.Repeat("0", 4096))
+	if err := tw.WriteHeader(&tar.Header{Name: "day/entry.csv", Mode: 0644, Size: int64(len(body))}); err != nil {
+		t.Fatalf("Failed test setup: tar.WriteHeader .. %s", err)
+	}
+	tw.Write(body)
+	tw.Close()
+	gw.Close()
+	p.write("export.tar.gz", b.Bytes())
+	p.epi.Archive = event.Archive{MaxSize: 1024, MaxEntries: 10}
+
+	p.watch("export.tar.gz")
+	t.Run("Test tar.gz exceeding MaxSize is dead-lettered", func(t *testing.T) {
+		act, err := p.deadLettered("export.tar.gz")
+		if err != nil {
+			t.Fatalf("NewWatcher() sidecar => %s, want dead-lettered", err)
+		}
+		if !strings.Contains(act.Error, "untar") || !strings.Contains(act.Error, "exceeds 1024 bytes") {
+			t.Errorf("NewWatcher() sidecar error => %q, want untar size limit exceeded", act.Error)
+		}
+	})
+}
+
+// Ensure that a kept archive is stored under its rendered key plus its compression suffix
+func Test_KeepArchiveKey(t *testing.T) {
+	p := newTestPipeline(t)
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gw)
+	body := []byte(strings.Repeat("0", 64))
+	if err := tw.WriteHeader(&tar.Header{Name: "day/entry.csv", Mode: 0644, Size: int64(len(body))}); err != nil {
+		t.Fatalf("Failed test setup: tar.WriteHeader .. %s", err)
+	}
+	tw.Write(body)
+	tw.Close()
+	gw.Close()
+	p.write("export.tar.gz", b.Bytes())
+	key, err := event.ParseKeyTemplate("{{.User}}/archive-{{.Name}}")
+	if err != nil {
+		t.Fatalf("ParseKeyTemplate() => %s", err)
+	}
+	rec := &recordDest{}
+	p.user.Key = key
+	p.user.Target = event.Target{Destinations: []event.Destination{rec}}
+	p.epi.Archive = event.Archive{Keep: true, SkipDirs: true, MaxSize: 1024, MaxEntries: 10}
+
+	p.watch("export.tar.gz")
+	t.Run("Test kept archive key", func(t *testing.T) {
+		exp := []string{"user1/archive-export/day/entry.csv", "user1/archive-export.tar.gz"}
+		if len(rec.objs) != len(exp) {
+			t.Fatalf("Put() called %d times, want %d", len(rec.objs), len(exp))
+		}
+		for i, k := range exp {
+			if rec.objs[i].Key != k {
+				t.Errorf("Put() #%d key => %q, want %q", i+1, rec.objs[i].Key, k)
+			}
+		}
+	})
+}
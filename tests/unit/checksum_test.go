@@ -0,0 +1,153 @@
+package sftppush
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// corruptDest is a Destination reporting an ETag not matching the content
+type corruptDest struct{}
+
+func (d corruptDest) String() string { return "corrupt://" }
+
+func (d corruptDest) Put(ctx context.Context, obj *event.Object) (*event.PutOutput, error) {
+	if _, err := io.Copy(ioutil.Discard, obj.Body); err != nil {
+		return nil, err
+	}
+	return &event.PutOutput{Location: obj.Key, ETag: "d41d8cd98f00b204e9800998ecf8427e"}, nil
+}
+
+// headDest is a Destination reporting no ETag on Put, like S3, and describing its objects with
+// the given ETag and encryption or failing to
+type headDest struct {
+	sync.Mutex
+	puts int
+	etag string
+	sse  string
+	err  error
+}
+
+func (d *headDest) String() string { return "head://" }
+
+func (d *headDest) Put(ctx context.Context, obj *event.Object) (*event.PutOutput, error) {
+	if _, err := io.Copy(ioutil.Discard, obj.Body); err != nil {
+		return nil, err
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.puts++
+	return &event.PutOutput{Location: obj.Key}, nil
+}
+
+func (d *headDest) Head(ctx context.Context, key string) (*event.ObjectInfo, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return &event.ObjectInfo{Key: key, ETag: d.etag, SSE: d.sse}, nil
+}
+
+// Ensure that a stored object differing from the bytes sent keeps the source file, whatever
+// the removal policy
+func Test_ChecksumMismatch(t *testing.T) {
+	var Results = []struct {
+		dest       event.Destination
+		deadLetter bool
+	}{
+		{nil, false}, // directory destination only
+		{corruptDest{}, true},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		p.write("plain.txt", []byte(strings.Repeat("plain text with more than 32 bytes\n", 1000)))
+		dsts := []event.Destination{event.NewDirDestination(p.out)}
+		if rr.dest != nil {
+			dsts = append(dsts, rr.dest)
+		}
+		p.user.Target = event.Target{Destinations: dsts, Removal: event.RemoveAny}
+		p.epi.Integrity = event.Integrity{Verify: true}
+
+		p.watch("plain.txt")
+		t.Run("Test ETag verification", func(t *testing.T) {
+			act, err := p.deadLettered("plain.txt")
+			if !rr.deadLetter {
+				if err == nil {
+					t.Errorf("NewWatcher() dead-lettered plain.txt, want verified upload")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewWatcher() sidecar => %s, want dead-lettered", err)
+			}
+			if !strings.Contains(act.Error, "checksum mismatch") {
+				t.Errorf("NewWatcher() sidecar error => %q, want checksum mismatch", act.Error)
+			}
+		})
+	}
+}
+
+// Ensure that objects which cannot be described or were encrypted with SSE-KMS by the bucket
+// count as uploaded, while a described object with a wrong ETag keeps the source file
+func Test_ChecksumHead(t *testing.T) {
+	var Results = []struct {
+		dest       *headDest
+		deadLetter bool
+	}{
+		{&headDest{err: errors.New("AccessDenied: Access Denied")}, false},
+		{&headDest{etag: "d41d8cd98f00b204e9800998ecf8427e", sse: "aws:kms"}, false},
+		{&headDest{etag: "d41d8cd98f00b204e9800998ecf8427e", sse: "AES256"}, true},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		p.write("plain.txt", []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+		p.user.Target = event.Target{Destinations: []event.Destination{rr.dest}}
+		p.epi.Retry = event.Retry{Attempts: 3}
+		p.epi.Integrity = event.Integrity{Verify: true}
+
+		p.watch("plain.txt")
+		t.Run("Test ETag fetched with Head", func(t *testing.T) {
+			_, err := p.deadLettered("plain.txt")
+			if deadLettered := err == nil; deadLettered != rr.deadLetter {
+				t.Errorf("NewWatcher() dead-lettered => %t, want %t", deadLettered, rr.deadLetter)
+			}
+			if !rr.deadLetter && rr.dest.puts != 1 {
+				t.Errorf("Put() called %d times, want 1", rr.dest.puts)
+			}
+		})
+	}
+}
+
+// Ensure that Source-Sha256 is the hash of the source file as received, not of the object
+// decompressed from it
+func Test_SourceSha256(t *testing.T) {
+	p := newTestPipeline(t)
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	gw.Write([]byte(strings.Repeat("id,value\n1,2\n", 10)))
+	gw.Close()
+	p.write("export.csv.gz", b.Bytes())
+	rec := &recordDest{}
+	p.user.Target = event.Target{Destinations: []event.Destination{rec}}
+	p.epi.Integrity = event.Integrity{Metadata: true}
+
+	p.watch("export.csv.gz")
+	t.Run("Test Source-Sha256 of the file as received", func(t *testing.T) {
+		if len(rec.objs) != 1 {
+			t.Fatalf("Put() called %d times, want 1", len(rec.objs))
+		}
+		sum := sha256.Sum256(b.Bytes())
+		if act, exp := rec.objs[0].Metadata["Source-Sha256"], hex.EncodeToString(sum[:]); act != exp {
+			t.Errorf("Metadata[Source-Sha256] => %q, want %q", act, exp)
+		}
+	})
+}
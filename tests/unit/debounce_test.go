@@ -0,0 +1,54 @@
+package sftppush
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that files closed repeatedly are pushed once with their final content, and that
+// temporary files renamed before they settled are not pushed at all
+func Test_Debounce(t *testing.T) {
+	p := newTestPipeline(t)
+	dst := &recordDest{}
+	p.user.Target = event.Target{Destinations: []event.Destination{dst}}
+	p.user.Storage = event.Storage{Metadata: true}
+	p.epi.Settle = 500 * time.Millisecond
+
+	stop := p.start()
+	line := strings.Repeat("plain text with more than 32 bytes\n", 10)
+	f := p.write("plain.txt", []byte(line))
+	tmp := p.write(".plain.csv.part", []byte(line))
+	time.Sleep(200 * time.Millisecond)
+	w, err := os.OpenFile(f, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed test setup: OpenFile .. %s", err)
+	}
+	if _, err := w.WriteString(line); err != nil {
+		t.Fatalf("Failed test setup: WriteString .. %s", err)
+	}
+	w.Close()
+	if err := os.Rename(tmp, filepath.Join(p.root, "plain.csv")); err != nil {
+		t.Fatalf("Failed test setup: Rename .. %s", err)
+	}
+	p.waitGone("plain.txt")
+	stop()
+
+	t.Run("Test debounce of repeated CloseWrite events", func(t *testing.T) {
+		dst.Lock()
+		defer dst.Unlock()
+		if len(dst.objs) != 1 {
+			t.Fatalf("NewWatcher() pushed %d objects, want 1", len(dst.objs))
+		}
+		if k := dst.objs[0].Key; k != "user1/data/plain.txt" {
+			t.Errorf("NewWatcher() pushed %s, want user1/data/plain.txt", k)
+		}
+		if n := dst.objs[0].Metadata["Source-Size"]; n != "700" {
+			t.Errorf("NewWatcher() pushed %s bytes, want 700", n)
+		}
+	})
+}
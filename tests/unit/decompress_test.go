@@ -0,0 +1,32 @@
+package sftppush
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that stage-2 hands files to the registered decompressor matching their magic bytes
+func Test_RegisterDecompressor(t *testing.T) {
+	event.RegisterDecompressor("application/x-sftppush-test", []byte("SFTPPUSHTEST"), []string{".spt"},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return nil, errors.New("test decompressor called")
+		})
+
+	p := newTestPipeline(t)
+	p.write("custom.spt", []byte("SFTPPUSHTEST payload of more than 32 bytes"))
+
+	p.watch("custom.spt")
+	t.Run("Test registered decompressor is used", func(t *testing.T) {
+		act, err := p.deadLettered("custom.spt")
+		if err != nil {
+			t.Fatalf("NewWatcher() sidecar => %s, want dead-lettered", err)
+		}
+		if !strings.Contains(act.Error, "test decompressor called") {
+			t.Errorf("NewWatcher() sidecar error => %q, want error of the registered decompressor", act.Error)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package sftppush
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// Ensure that DirDestination stores, describes and removes objects below its root only
+func Test_DirDestination(t *testing.T) {
+	root, err := ioutil.TempDir("", "sftppush")
+	if err != nil {
+		t.Fatalf("Failed test setup: TempDir .. %s", err)
+	}
+	defer os.RemoveAll(root)
+
+	d := event.NewDirDestination(root)
+	ctx := context.Background()
+
+	t.Run("Test Put, Head and Delete", func(t *testing.T) {
+		out, err := d.Put(ctx, &event.Object{Key: "a/b.csv", Body: strings.NewReader("x,y\n")})
+		if err != nil {
+			t.Fatalf("Put() => %s, want nil", err)
+		}
+		sum := md5.Sum([]byte("x,y\n"))
+		if out.ETag != hex.EncodeToString(sum[:]) {
+			t.Errorf("Put() ETag => %s, want %x", out.ETag, sum)
+		}
+		info, err := d.Head(ctx, "a/b.csv")
+		if err != nil || info.Size != 4 || info.ETag != out.ETag {
+			t.Errorf("Head() => %+v, %v, want size 4 and ETag %s", info, err, out.ETag)
+		}
+		if err := d.Delete(ctx, "a/b.csv"); err != nil {
+			t.Errorf("Delete() => %s, want nil", err)
+		}
+		if _, err := d.Head(ctx, "a/b.csv"); err != event.ErrNotFound {
+			t.Errorf("Head() after Delete => %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Test keys outside of the root are rejected", func(t *testing.T) {
+		if _, err := d.Put(ctx, &event.Object{Key: "../escape", Body: strings.NewReader("x")}); err == nil {
+			t.Errorf("Put(../escape) => nil, want error")
+		}
+	})
+}
+
+// Ensure that the whole pipeline runs against a local directory destination
+func Test_PipelineDirDestination(t *testing.T) {
+	p := newTestPipeline(t)
+	content := strings.Repeat("id,value\n1,2\n", 10)
+	var b bytes.Buffer
+	gw := gzip.NewWriter(&b)
+	gw.Write([]byte(content))
+	gw.Close()
+	p.write("export.csv.gz", b.Bytes())
+	p.user.Target = event.Target{Destinations: []event.Destination{event.NewDirDestination(p.out)}, Prefix: "incoming"}
+
+	p.watch("export.csv.gz")
+	t.Run("Test gzip file is decompressed into the directory destination", func(t *testing.T) {
+		act, err := ioutil.ReadFile(filepath.Join(p.out, "incoming", "user1", "data", "export.csv"))
+		if err != nil {
+			t.Fatalf("ReadFile() => %s, want pushed object", err)
+		}
+		if string(act) != content {
+			t.Errorf("pushed object => %q, want %q", act, content)
+		}
+		if _, err := p.deadLettered("export.csv.gz"); err == nil {
+			t.Errorf("NewWatcher() dead-lettered export.csv.gz")
+		}
+	})
+}
+
+// Ensure that the removal policy decides whether a partially pushed file counts as uploaded
+func Test_FanOut(t *testing.T) {
+	var Results = []struct {
+		removal    event.Removal
+		uploaded   bool
+		deadLetter bool
+	}{
+		{event.RemoveAny, true, false},
+		{event.RemoveQuorum, false, true},
+		{event.RemoveAll, false, true},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		content := strings.Repeat("plain text with more than 32 bytes\n", 1000)
+		p.write("plain.txt", []byte(content))
+		// a regular file as root makes the second destination fail
+		broken := filepath.Join(p.root, "broken")
+		if err := ioutil.WriteFile(broken, nil, 0644); err != nil {
+			t.Fatalf("Failed test setup: WriteFile .. %s", err)
+		}
+		p.user.Target = event.Target{
+			Destinations: []event.Destination{event.NewDirDestination(p.out), event.NewDirDestination(broken)},
+			Removal:      rr.removal,
+		}
+
+		p.watch("plain.txt")
+		t.Run("Test removal policy with one of two destinations failing", func(t *testing.T) {
+			act, err := ioutil.ReadFile(filepath.Join(p.out, "user1", "data", "plain.txt"))
+			if err != nil || string(act) != content {
+				t.Errorf("removal %d: working destination => %d bytes, %v, want %d bytes", rr.removal, len(act), err, len(content))
+			}
+			d, err := p.deadLettered("plain.txt")
+			if (err == nil) != rr.deadLetter {
+				t.Fatalf("removal %d: dead-lettered => %t, want %t", rr.removal, err == nil, rr.deadLetter)
+			}
+			if rr.deadLetter && !strings.Contains(d.Error, "1 of 2 destinations failed") {
+				t.Errorf("removal %d: sidecar => %s, want 1 of 2 destinations failed", rr.removal, d.Error)
+			}
+		})
+	}
+}
+
+// Ensure that the failed destinations of every archive entry are reported, even though the
+// removal policy counts the archive as uploaded
+func Test_FanOutArchive(t *testing.T) {
+	p := newTestPipeline(t)
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, n := range []string{"a.csv", "b.csv"} {
+		w, _ := zw.Create(n)
+		w.Write([]byte(strings.Repeat("id,value\n1,2\n", 10)))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed test setup: zip.Close .. %s", err)
+	}
+	p.write("export.zip", b.Bytes())
+	broken := filepath.Join(p.root, "broken")
+	if err := ioutil.WriteFile(broken, nil, 0644); err != nil {
+		t.Fatalf("Failed test setup: WriteFile .. %s", err)
+	}
+	p.user.Target = event.Target{
+		Destinations: []event.Destination{event.NewDirDestination(p.out), event.NewDirDestination(broken)},
+		Removal:      event.RemoveAny,
+	}
+	hook := test.NewLocal(p.lg)
+
+	p.watch("export.zip")
+	t.Run("Test failed destinations of archive entries are reported", func(t *testing.T) {
+		n := 0
+		for _, e := range hook.AllEntries() {
+			if e.Level == logrus.WarnLevel && strings.HasPrefix(e.Message, "Results:") && strings.Contains(e.Message, broken) {
+				n++
+			}
+		}
+		if n != 2 {
+			t.Errorf("results() warned about %d failed destinations, want 2", n)
+		}
+	})
+}
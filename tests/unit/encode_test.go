@@ -0,0 +1,26 @@
+package sftppush
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that unknown output compressions fail the upload before anything is sent
+func Test_OutputCompression(t *testing.T) {
+	p := newTestPipeline(t)
+	p.write("plain.txt", []byte("plain text with more than 32 bytes"))
+	p.user.Output = event.Output{Compression: "lz4"}
+
+	p.watch("plain.txt")
+	t.Run("Test unknown output compression is dead-lettered", func(t *testing.T) {
+		act, err := p.deadLettered("plain.txt")
+		if err != nil {
+			t.Fatalf("NewWatcher() sidecar => %s, want dead-lettered", err)
+		}
+		if !strings.Contains(act.Error, `unknown output compression "lz4"`) {
+			t.Errorf("NewWatcher() sidecar error => %q, want unknown output compression", act.Error)
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package sftppush
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that inconsistent server-side encryption settings are reported at startup
+func Test_EncryptionValidate(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	var Results = []struct {
+		in  event.Encryption
+		err bool
+	}{
+		{event.Encryption{}, false},
+		{event.Encryption{SSE: "AES256"}, false},
+		{event.Encryption{SSE: "aws:kms", KMSKeyID: "alias/tenant1", BucketKey: true}, false},
+		{event.Encryption{CustomerKey: key}, false},
+		{event.Encryption{SSE: "aws:kms2"}, true},
+		{event.Encryption{KMSKeyID: "alias/tenant1"}, true},
+		{event.Encryption{SSE: "AES256", KMSKeyID: "alias/tenant1"}, true},
+		{event.Encryption{SSE: "AES256", BucketKey: true}, true},
+		{event.Encryption{SSE: "AES256", CustomerKey: key}, true},
+		{event.Encryption{CustomerKey: key[:16]}, true},
+	}
+
+	t.Run("Test Encryption settings", func(t *testing.T) {
+		for _, rr := range Results {
+			if err := rr.in.Validate(); (err != nil) != rr.err {
+				t.Errorf("Validate(%+v) => %v, want error %t", rr.in, err, rr.err)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package sftppush
+
+import (
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that key templates render partitioned layouts and reject keys leaving the bucket root
+func Test_RenderKey(t *testing.T) {
+	d := event.KeyData{
+		User:        "user1",
+		Dir:         "",
+		Name:        "export.csv",
+		Ext:         ".csv",
+		ModTime:     time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+		Size:        42,
+		ContentType: "text/csv",
+	}
+	var Results = []struct {
+		in  string
+		out string
+		err bool
+	}{
+		{`{{.User}}/dt={{.ModTime.Format "2006-01-02"}}/{{.Name}}`, "user1/dt=2026-10-18/export.csv", false},
+		{`{{.User}}/{{.Dir}}/{{.Name}}`, "user1/export.csv", false},
+		{`/{{.ContentType}}/{{.Size}}{{.Ext}}`, "text/csv/42.csv", false},
+		{`{{.User}}/../{{.Name}}`, "", true},
+		{`{{.Dir}}`, "", true},
+		{`{{.Unknown}}`, "", true},
+	}
+
+	t.Run("Test RenderKey templates", func(t *testing.T) {
+		for _, rr := range Results {
+			tmpl, err := event.ParseKeyTemplate(rr.in)
+			if err != nil {
+				t.Fatalf("ParseKeyTemplate(%s) => %s", rr.in, err)
+			}
+			act, err := event.RenderKey(tmpl, d)
+			if (err != nil) != rr.err || act != rr.out {
+				t.Errorf("RenderKey(%s) => %q, %v, want %q, error %t", rr.in, act, err, rr.out, rr.err)
+			}
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package sftppush
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+	"github.com/sirupsen/logrus"
+)
+
+// testPipeline is a single user pushing files from <root>/home/user1/data, with the dead-letter
+// and quarantine directories and a directory bucket of the user below the same temporary root
+type testPipeline struct {
+	t    *testing.T
+	root string
+	src  string
+	dl   string
+	qt   string
+	out  string
+	user *event.UserInfo
+	epi  *event.EventPushInfo
+	lg   *logrus.Logger
+}
+
+// newTestPipeline sets up the directories, the user and the push info with a single worker
+// and a single attempt, tests adjust both before running the pipeline
+func newTestPipeline(t *testing.T) *testPipeline {
+	t.Helper()
+	root, err := ioutil.TempDir("", "sftppush")
+	if err != nil {
+		t.Fatalf("Failed test setup: TempDir .. %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	userpath := root + "/home/"
+	p := &testPipeline{
+		t:    t,
+		root: root,
+		src:  filepath.Join(userpath, "user1", "data"),
+		dl:   filepath.Join(root, "deadletter", "user1"),
+		qt:   filepath.Join(root, "quarantine", "user1"),
+		out:  filepath.Join(root, "bucket"),
+	}
+	if err := os.MkdirAll(p.src, 0755); err != nil {
+		t.Fatalf("Failed test setup: MkdirAll .. %s", err)
+	}
+	p.user = &event.UserInfo{Name: "user1", DeadLetter: p.dl}
+	p.epi = &event.EventPushInfo{
+		Userpath:  &userpath,
+		Watchdirs: []string{p.src},
+		Users:     map[string]*event.UserInfo{p.src: p.user},
+		Workers:   1,
+		Retry:     event.Retry{Attempts: 1},
+		Grace:     5 * time.Second,
+		Results:   make(chan *event.ResultInfo),
+	}
+	p.lg = logrus.New()
+	p.lg.Out = ioutil.Discard
+	return p
+}
+
+// in returns the pipeline reporting to t, for use within subtests
+func (p testPipeline) in(t *testing.T) *testPipeline {
+	p.t = t
+	return &p
+}
+
+// write creates the file below the source directory and returns its path
+func (p *testPipeline) write(name string, content []byte) string {
+	p.t.Helper()
+	f := filepath.Join(p.src, name)
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		p.t.Fatalf("Failed test setup: MkdirAll .. %s", err)
+	}
+	if err := ioutil.WriteFile(f, content, 0644); err != nil {
+		p.t.Fatalf("Failed test setup: WriteFile .. %s", err)
+	}
+	if err := os.Chmod(f, 0644); err != nil { // independent of the umask
+		p.t.Fatalf("Failed test setup: Chmod .. %s", err)
+	}
+	return f
+}
+
+// deadLetter creates the file in the dead-letter directory along with its sidecar, as if it
+// had failed in the source directory, and returns its path
+func (p *testPipeline) deadLetter(name string, content []byte) string {
+	p.t.Helper()
+	f := filepath.Join(p.dl, "data", name)
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		p.t.Fatalf("Failed test setup: MkdirAll .. %s", err)
+	}
+	if err := ioutil.WriteFile(f, content, 0644); err != nil {
+		p.t.Fatalf("Failed test setup: WriteFile .. %s", err)
+	}
+	sidecar, _ := json.Marshal(event.DeadLetter{
+		EventInfo: event.EventInfo{Event: event.Event{AbsLoc: filepath.Join(p.src, name)}},
+		Error:     "initial",
+	})
+	if err := ioutil.WriteFile(f+".deadletter.json", sidecar, 0644); err != nil {
+		p.t.Fatalf("Failed test setup: WriteFile .. %s", err)
+	}
+	return f
+}
+
+// retry pushes all dead-lettered files once more
+func (p *testPipeline) retry() {
+	p.t.Helper()
+	e := &event.FsEventOps{}
+	if err := e.Retry(p.epi, p.lg); err != nil {
+		p.t.Fatalf("Retry() => %s, want nil", err)
+	}
+}
+
+// start runs the watch pipeline with a journal, files already in the source directory are
+// backfilled. The returned stop waits for all events in progress.
+func (p *testPipeline) start() (stop func()) {
+	p.t.Helper()
+	halt := p.run()
+	return func() {
+		p.t.Helper()
+		if err := halt(); err != nil {
+			p.t.Errorf("NewWatcher() => %s, want nil", err)
+		}
+	}
+}
+
+// run is start returning the error of NewWatcher from stop
+func (p *testPipeline) run() (stop func() error) {
+	p.t.Helper()
+	q, err := event.OpenJournal(filepath.Join(p.root, "queue.journal"))
+	if err != nil {
+		p.t.Fatalf("Failed test setup: OpenJournal .. %s", err)
+	}
+	p.epi.Queue = q
+	p.epi.Backfill = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		e := &event.FsEventOps{}
+		stopped <- e.NewWatcher(ctx, p.epi, p.lg)
+	}()
+	// give NewWatcher time to add its watches
+	time.Sleep(200 * time.Millisecond)
+	return func() error {
+		cancel()
+		err := <-stopped
+		q.Close()
+		return err
+	}
+}
+
+// waitGone waits until the files left the source directory, pushed, dead-lettered or
+// quarantined
+func (p *testPipeline) waitGone(names ...string) {
+	p.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, n := range names {
+		for {
+			if _, err := os.Stat(filepath.Join(p.src, n)); os.IsNotExist(err) {
+				break
+			}
+			if time.Now().After(deadline) {
+				p.t.Fatalf("NewWatcher() left %s in source directory", n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// watch runs the watch pipeline until the files left the source directory
+func (p *testPipeline) watch(names ...string) {
+	p.t.Helper()
+	stop := p.start()
+	defer stop()
+	p.waitGone(names...)
+}
+
+// deadLettered returns the sidecar of the dead-lettered file
+func (p *testPipeline) deadLettered(name string) (event.DeadLetter, error) {
+	return readLetter(filepath.Join(p.dl, "data", name+".deadletter.json"))
+}
+
+// quarantined returns the sidecar of the quarantined file
+func (p *testPipeline) quarantined(name string) (event.DeadLetter, error) {
+	return readLetter(filepath.Join(p.qt, "data", name+".quarantine.json"))
+}
+
+func readLetter(p string) (event.DeadLetter, error) {
+	var d event.DeadLetter
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return d, err
+	}
+	err = json.Unmarshal(b, &d)
+	return d, err
+}
@@ -0,0 +1,107 @@
+package sftppush
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that the Journal replays pending events only and drops duplicates
+func Test_Journal(t *testing.T) {
+	root, err := ioutil.TempDir("", "sftppush")
+	if err != nil {
+		t.Fatalf("Failed test setup: TempDir .. %s", err)
+	}
+	defer os.RemoveAll(root)
+	p := filepath.Join(root, "queue.journal")
+
+	ev := func(loc string) event.EventInfo {
+		return event.EventInfo{Event: event.Event{AbsLoc: loc, Op: "CLOSEWRITE"}}
+	}
+
+	t.Run("Test Journal pending events survive reopening", func(t *testing.T) {
+		j, err := event.OpenJournal(p)
+		if err != nil {
+			t.Fatalf("OpenJournal(%s) => %s", p, err)
+		}
+		for _, loc := range []string{"/tmp/a.gz", "/tmp/b.gz"} {
+			if ok, err := j.Add(ev(loc)); !ok || err != nil {
+				t.Errorf("Add(%s) => %t, %v, want true, nil", loc, ok, err)
+			}
+		}
+		if ok, _ := j.Add(ev("/tmp/a.gz")); ok {
+			t.Errorf("Add(/tmp/a.gz) twice => true, want false")
+		}
+		if err := j.Done(ev("/tmp/a.gz")); err != nil {
+			t.Errorf("Done(/tmp/a.gz) => %s", err)
+		}
+		// simulate a crash, the journal is not closed
+
+		r, err := event.OpenJournal(p)
+		if err != nil {
+			t.Fatalf("OpenJournal(%s) => %s", p, err)
+		}
+		defer r.Close()
+		act := r.Pending()
+		if len(act) != 1 || act[0].Event.AbsLoc != "/tmp/b.gz" {
+			t.Errorf("Pending() => %+v, want only /tmp/b.gz", act)
+		}
+	})
+}
+
+// rewriteDest is a Destination appending to the source file during its first upload, as a
+// client rewriting the file while it is pushed
+type rewriteDest struct {
+	src   string
+	sizes []int64
+}
+
+func (d *rewriteDest) String() string { return "rewrite://" }
+
+func (d *rewriteDest) Put(ctx context.Context, obj *event.Object) (*event.PutOutput, error) {
+	n, err := io.Copy(ioutil.Discard, obj.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.sizes) == 0 {
+		f, err := os.OpenFile(d.src, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		f.WriteString("appended while uploading\n")
+		f.Close()
+	}
+	d.sizes = append(d.sizes, n)
+	return &event.PutOutput{Location: obj.Key}, nil
+}
+
+// Ensure that a file rewritten during its upload is kept and pushed again with its new content
+func Test_RewrittenDuringUpload(t *testing.T) {
+	p := newTestPipeline(t)
+	content := strings.Repeat("plain text with more than 32 bytes\n", 10)
+	f := p.write("plain.txt", []byte(content))
+	dst := &rewriteDest{src: f}
+	p.user.Target = event.Target{Destinations: []event.Destination{dst}}
+
+	p.watch("plain.txt")
+	t.Run("Test rewritten file is pushed again", func(t *testing.T) {
+		exp := []int64{int64(len(content)), int64(len(content) + len("appended while uploading\n"))}
+		if len(dst.sizes) != len(exp) {
+			t.Fatalf("Put() called %d times, want %d", len(dst.sizes), len(exp))
+		}
+		for i := range exp {
+			if dst.sizes[i] != exp[i] {
+				t.Errorf("Put() #%d => %d bytes, want %d", i+1, dst.sizes[i], exp[i])
+			}
+		}
+		if n := len(p.epi.Queue.Pending()); n != 0 {
+			t.Errorf("Journal pending => %d events, want 0", n)
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package sftppush
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that files are only pushed once marked ready, the marker being removed along with the
+// last file it marks
+func Test_ReadyMarker(t *testing.T) {
+	var Results = []struct {
+		marker string
+		pushed bool
+	}{
+		{"", false},
+		{"plain1.txt.done", true},
+		{"_SUCCESS", true},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		files := []string{"plain1.txt"}
+		if rr.marker == "_SUCCESS" {
+			files = append(files, "plain2.txt")
+		}
+		for _, n := range files {
+			p.write(n, []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+		}
+		if rr.marker != "" {
+			p.write(rr.marker, nil)
+		}
+		p.user.Ready = event.Ready{Markers: []string{"{name}.done", "_SUCCESS"}}
+		p.user.Target = event.Target{Destinations: []event.Destination{event.NewDirDestination(p.out)}}
+
+		stop := p.start()
+		if rr.pushed {
+			p.waitGone(append(files, rr.marker)...)
+		} else {
+			time.Sleep(500 * time.Millisecond)
+		}
+		stop()
+		t.Run("Test readiness marker "+rr.marker, func(t *testing.T) {
+			for _, n := range files {
+				_, err := os.Stat(filepath.Join(p.out, "user1", "data", n))
+				if pushed := err == nil; pushed != rr.pushed {
+					t.Errorf("NewWatcher() pushed %s => %t, want %t", n, pushed, rr.pushed)
+				}
+				_, err = os.Stat(filepath.Join(p.src, n))
+				if kept := err == nil; kept == rr.pushed {
+					t.Errorf("NewWatcher() kept %s in source directory => %t, want %t", n, kept, !rr.pushed)
+				}
+			}
+		})
+	}
+}
+
+// Ensure that payloads closed before their marker arrived are held back by the watch pipeline
+// and released by the marker, a file level one or a directory level one marking all files
+// dropped before
+func Test_ReadyMarkerLater(t *testing.T) {
+	var Results = []struct {
+		marker string
+		files  []string
+	}{
+		{"plain1.txt.done", []string{"plain1.txt"}},
+		{"_SUCCESS", []string{"plain1.txt", "plain2.txt"}},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		p.user.Ready = event.Ready{Markers: []string{"{name}.done", "_SUCCESS"}}
+		p.user.Target = event.Target{Destinations: []event.Destination{event.NewDirDestination(p.out)}}
+
+		stop := p.start()
+		for _, n := range rr.files {
+			p.write(n, []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+		}
+		time.Sleep(500 * time.Millisecond)
+		early := make(map[string]bool)
+		for _, n := range rr.files {
+			_, err := os.Stat(filepath.Join(p.out, "user1", "data", n))
+			early[n] = err == nil
+		}
+		p.write(rr.marker, nil)
+		p.waitGone(append(rr.files, rr.marker)...)
+		stop()
+
+		t.Run("Test readiness marker after payload "+rr.marker, func(t *testing.T) {
+			for _, n := range rr.files {
+				if early[n] {
+					t.Errorf("NewWatcher() pushed %s before marker %s", n, rr.marker)
+				}
+				if _, err := os.Stat(filepath.Join(p.out, "user1", "data", n)); err != nil {
+					t.Errorf("NewWatcher() => %s, want %s pushed", err, n)
+				}
+			}
+		})
+	}
+}
+
+// Ensure that dead-lettered files are pushed by Retry without a marker, a directory level one
+// is removed once no file of its directory is left in the source directory
+func Test_ReadyRetry(t *testing.T) {
+	p := newTestPipeline(t)
+	p.deadLetter("plain1.txt", []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+	p.user.Ready = event.Ready{Markers: []string{"{name}.done", "_SUCCESS"}}
+	p.user.Target = event.Target{Destinations: []event.Destination{event.NewDirDestination(p.out)}}
+
+	p.retry()
+	t.Run("Test restored file without marker", func(t *testing.T) {
+		if _, err := os.Stat(filepath.Join(p.out, "user1", "data", "plain1.txt")); err != nil {
+			t.Errorf("Retry() => %s, want plain1.txt pushed", err)
+		}
+		if _, err := os.Stat(filepath.Join(p.src, "plain1.txt")); !os.IsNotExist(err) {
+			t.Errorf("Retry() left plain1.txt in source directory")
+		}
+	})
+}
+
+// Ensure that marker patterns matching every file are rejected
+func Test_ReadyValidate(t *testing.T) {
+	var Results = []struct {
+		in  []string
+		err bool
+	}{
+		{[]string{"{name}.done", "{name}.ready", "_SUCCESS", "*.manifest"}, false},
+		{[]string{"{name}"}, true},
+		{[]string{"{name}.{name}"}, true},
+		{[]string{"[_SUCCESS"}, true},
+	}
+
+	t.Run("Test Ready markers", func(t *testing.T) {
+		for _, rr := range Results {
+			if err := (event.Ready{Markers: rr.in}).Validate(); (err != nil) != rr.err {
+				t.Errorf("Validate(%v) => %v, want error %t", rr.in, err, rr.err)
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package sftppush
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that subdirectories created after startup are watched for recursive users only, their
+// files being pushed under the matching nested key
+func Test_Recursive(t *testing.T) {
+	var Results = []struct {
+		recursive bool
+	}{
+		{true},
+		{false},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		p.user.Recursive = rr.recursive
+		p.user.Target = event.Target{Destinations: []event.Destination{event.NewDirDestination(p.out)}}
+
+		stop := p.start()
+		content := strings.Repeat("plain text with more than 32 bytes\n", 10)
+		p.write(filepath.Join("2026", "10", "plain1.txt"), []byte(content))
+		time.Sleep(200 * time.Millisecond)
+		p.write(filepath.Join("2026", "10", "plain2.txt"), []byte(content))
+		if rr.recursive {
+			p.waitGone(filepath.Join("2026", "10", "plain1.txt"), filepath.Join("2026", "10", "plain2.txt"))
+		} else {
+			time.Sleep(500 * time.Millisecond)
+		}
+		stop()
+
+		t.Run("Test subdirectories created after startup", func(t *testing.T) {
+			for _, n := range []string{"plain1.txt", "plain2.txt"} {
+				act, err := ioutil.ReadFile(filepath.Join(p.out, "user1", "data", "2026", "10", n))
+				if pushed := err == nil; pushed != rr.recursive {
+					t.Errorf("recursive %t: pushed %s => %t, want %t", rr.recursive, n, pushed, rr.recursive)
+				}
+				if err == nil && string(act) != content {
+					t.Errorf("recursive %t: pushed %s => %d bytes, want %d", rr.recursive, n, len(act), len(content))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package sftppush
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that Reload starts and stops watching sources, and that a rejected config leaves the
+// watches of the running one untouched
+func Test_Reload(t *testing.T) {
+	p := newTestPipeline(t)
+	rec := &recordDest{}
+	p.user.Target = event.Target{Destinations: []event.Destination{rec}}
+	other := filepath.Join(filepath.Dir(p.src), "other")
+	for _, d := range []string{other, filepath.Join(p.src, "sub")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatalf("Failed test setup: MkdirAll .. %s", err)
+		}
+	}
+	content := []byte(strings.Repeat("plain text with more than 32 bytes\n", 10))
+	// next returns a config of the sources, recursive are watched along with their subdirectories
+	next := func(dirs []string, recursive ...string) *event.EventPushInfo {
+		n := &event.EventPushInfo{Watchdirs: dirs, Users: make(map[string]*event.UserInfo)}
+		for _, d := range dirs {
+			u := *p.user
+			for _, r := range recursive {
+				u.Recursive = u.Recursive || r == d
+			}
+			n.Users[d] = &u
+		}
+		return n
+	}
+	pushed := func(key string) bool {
+		rec.Lock()
+		defer rec.Unlock()
+		for _, o := range rec.objs {
+			if o.Key == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	stop := p.start()
+	defer stop()
+	e := &event.FsEventOps{}
+
+	t.Run("Test rejected config keeps the running watches", func(t *testing.T) {
+		p := p.in(t)
+		// the source switched to recursive is rolled back once the missing one fails
+		err := e.Reload(p.epi, next([]string{p.src, filepath.Join(p.root, "missing")}, p.src), p.lg)
+		if err == nil {
+			t.Fatalf("Reload() => nil, want error for missing source")
+		}
+		p.write("sub/late.txt", content)
+		p.write("first.txt", content)
+		p.waitGone("first.txt")
+		time.Sleep(200 * time.Millisecond)
+		if pushed("user1/data/sub/late.txt") {
+			t.Errorf("Reload() kept watching subdirectories of a rejected recursive source")
+		}
+	})
+
+	t.Run("Test added source is watched", func(t *testing.T) {
+		p := p.in(t)
+		if err := e.Reload(p.epi, next([]string{p.src, other}), p.lg); err != nil {
+			t.Fatalf("Reload() => %s, want nil", err)
+		}
+		p.write("../other/added.txt", content)
+		p.waitGone("../other/added.txt")
+		if !pushed("user1/other/added.txt") {
+			t.Errorf("Reload() did not push from added source")
+		}
+	})
+
+	t.Run("Test removed source is no longer watched", func(t *testing.T) {
+		p := p.in(t)
+		if err := e.Reload(p.epi, next([]string{other}), p.lg); err != nil {
+			t.Fatalf("Reload() => %s, want nil", err)
+		}
+		p.write("removed.txt", content)
+		p.write("../other/kept.txt", content)
+		p.waitGone("../other/kept.txt")
+		time.Sleep(200 * time.Millisecond)
+		if pushed("user1/data/removed.txt") {
+			t.Errorf("Reload() pushed from removed source")
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package sftppush
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that Retry restores dead-lettered files and dead-letters them again if they still fail
+func Test_Retry(t *testing.T) {
+	p := newTestPipeline(t)
+	// a gzip header with reserved flags set fails in stage-2 before any upload
+	p.deadLetter("corrupt.gz", append([]byte{0x1f, 0x8b, 0x08, 0xe0}, []byte("no deflate stream, more than 32 bytes")...))
+
+	p.retry()
+	t.Run("Test Retry dead-letters failing files again", func(t *testing.T) {
+		act, err := p.deadLettered("corrupt.gz")
+		if err != nil {
+			t.Fatalf("Retry() sidecar => %s, want dead-lettered again", err)
+		}
+		if act.Error == "initial" || act.EventInfo.Event.AbsLoc != filepath.Join(p.src, "corrupt.gz") {
+			t.Errorf("Retry() sidecar => %+v, want new error for %s", act, filepath.Join(p.src, "corrupt.gz"))
+		}
+		if _, err := os.Stat(filepath.Join(p.src, "corrupt.gz")); !os.IsNotExist(err) {
+			t.Errorf("Retry() left %s in source directory", filepath.Join(p.src, "corrupt.gz"))
+		}
+	})
+}
+
+// Ensure that the backoff doubles per attempt up to MaxBackoff, with jitter of at most half
+func Test_RetryDelay(t *testing.T) {
+	var Results = []struct {
+		in       event.Retry
+		attempt  int
+		min, max time.Duration
+	}{
+		{event.Retry{Backoff: time.Second}, 1, 500 * time.Millisecond, time.Second},
+		{event.Retry{Backoff: time.Second}, 3, 2 * time.Second, 4 * time.Second},
+		{event.Retry{Backoff: time.Second}, 5, 8 * time.Second, 16 * time.Second},
+		{event.Retry{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 2, time.Second, 2 * time.Second},
+		{event.Retry{Backoff: time.Second, MaxBackoff: 5 * time.Second}, 5, 2500 * time.Millisecond, 5 * time.Second},
+		{event.Retry{Backoff: time.Second}, 100, math.MaxInt64 / 4, math.MaxInt64},
+		{event.Retry{}, 3, 0, 0},
+	}
+
+	t.Run("Test Retry delays", func(t *testing.T) {
+		for _, rr := range Results {
+			for i := 0; i < 20; i++ {
+				if d := rr.in.Delay(rr.attempt); d < rr.min || d > rr.max {
+					t.Errorf("%+v.Delay(%d) => %s, want between %s and %s", rr.in, rr.attempt, d, rr.min, rr.max)
+					break
+				}
+			}
+		}
+	})
+}
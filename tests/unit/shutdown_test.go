@@ -0,0 +1,53 @@
+package sftppush
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that NewWatcher waits up to the grace period for uploads in progress on shutdown,
+// returning ErrAbandoned for uploads outliving it, and that a zero grace does not wait
+func Test_Shutdown(t *testing.T) {
+	var Results = []struct {
+		grace     time.Duration
+		chunk     int
+		abandoned bool
+	}{
+		{5 * time.Second, 50, false},
+		{200 * time.Millisecond, 1, true},
+		{0, 1, true},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		p.write("plain.txt", []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+		dst := &trickleDest{chunk: rr.chunk, every: 10 * time.Millisecond}
+		p.user.Target = event.Target{Destinations: []event.Destination{dst}}
+		p.epi.Grace = rr.grace
+
+		stop := p.run()
+		for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&dst.puts) == 0; {
+			if time.Now().After(deadline) {
+				t.Fatalf("NewWatcher() did not start the upload")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		begin := time.Now()
+		err := stop()
+		elapsed := time.Since(begin)
+
+		t.Run(fmt.Sprintf("Test shutdown with grace %s", rr.grace), func(t *testing.T) {
+			if abandoned := errors.Is(err, event.ErrAbandoned); abandoned != rr.abandoned {
+				t.Errorf("NewWatcher() => %v, want abandoned %t", err, rr.abandoned)
+			}
+			if rr.abandoned && elapsed > rr.grace+time.Second {
+				t.Errorf("NewWatcher() returned after %s, want about %s", elapsed, rr.grace)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package sftppush
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that payloads are verified against their checksum sidecar, mismatches being quarantined
+// along with the sidecar and matches being pushed next to it
+func Test_Sidecar(t *testing.T) {
+	content := strings.Repeat("plain text with more than 32 bytes\n", 100)
+	sum := sha256.Sum256([]byte(content))
+	var Results = []struct {
+		digest      string
+		quarantined bool
+	}{
+		{hex.EncodeToString(sum[:]), false},
+		{strings.Repeat("0", 64), true},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		p.write("plain.txt", []byte(content))
+		sc := p.write("plain.txt.sha256", []byte(rr.digest+"  plain.txt\n"))
+		p.user.Quarantine = p.qt
+		p.user.Sidecar = event.Sidecar{Verify: true, Require: true, Upload: true}
+		p.user.Target = event.Target{Destinations: []event.Destination{event.NewDirDestination(p.out)}}
+
+		p.watch("plain.txt", "plain.txt.sha256")
+		t.Run("Test payload verified against its sidecar", func(t *testing.T) {
+			if _, err := os.Stat(sc); !os.IsNotExist(err) {
+				t.Errorf("NewWatcher() left %s in source directory", sc)
+			}
+			if !rr.quarantined {
+				for _, n := range []string{"plain.txt", "plain.txt.sha256"} {
+					if _, err := os.Stat(filepath.Join(p.out, "user1", "data", n)); err != nil {
+						t.Errorf("NewWatcher() => %s, want %s pushed", err, n)
+					}
+				}
+				return
+			}
+			act, err := p.quarantined("plain.txt")
+			if err != nil {
+				t.Fatalf("NewWatcher() quarantine sidecar => %s, want quarantined", err)
+			}
+			if !strings.Contains(act.Error, "checksum mismatch") {
+				t.Errorf("NewWatcher() quarantine error => %q, want checksum mismatch", act.Error)
+			}
+			if _, err := os.Stat(filepath.Join(p.qt, "data", "plain.txt.sha256")); err != nil {
+				t.Errorf("NewWatcher() => %s, want checksum sidecar quarantined", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package sftppush
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// recordDest is a Destination recording the objects put
+type recordDest struct {
+	sync.Mutex
+	objs []event.Object
+}
+
+func (d *recordDest) String() string { return "record://" }
+
+func (d *recordDest) Put(ctx context.Context, obj *event.Object) (*event.PutOutput, error) {
+	if _, err := io.Copy(ioutil.Discard, obj.Body); err != nil {
+		return nil, err
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.objs = append(d.objs, *obj)
+	return &event.PutOutput{Location: obj.Key}, nil
+}
+
+// Ensure that invalid storage classes, ACLs and tag keys are reported at startup
+func Test_StorageValidate(t *testing.T) {
+	tags, err := event.ParseTagTemplates(map[string]string{"tenant": "{{.User}}"})
+	if err != nil {
+		t.Fatalf("ParseTagTemplates() => %s", err)
+	}
+	bad, err := event.ParseTagTemplates(map[string]string{"tenant?": "x"})
+	if err != nil {
+		t.Fatalf("ParseTagTemplates() => %s", err)
+	}
+	var Results = []struct {
+		in  event.Storage
+		err bool
+	}{
+		{event.Storage{}, false},
+		{event.Storage{Class: "GLACIER_IR", ACL: "bucket-owner-full-control", Tags: tags}, false},
+		{event.Storage{Class: "COLD"}, true},
+		{event.Storage{ACL: "everyone"}, true},
+		{event.Storage{Tags: bad}, true},
+	}
+
+	t.Run("Test Storage settings", func(t *testing.T) {
+		for _, rr := range Results {
+			if err := rr.in.Validate(); (err != nil) != rr.err {
+				t.Errorf("Validate(%+v) => %v, want error %t", rr.in, err, rr.err)
+			}
+		}
+	})
+}
+
+// Ensure that storage class, rendered tags and source metadata are passed to the destination
+func Test_StorageObject(t *testing.T) {
+	p := newTestPipeline(t)
+	p.write("export (1).txt", []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+	tags, err := event.ParseTagTemplates(map[string]string{"tenant": "{{.User}}", "file": "{{.Name}}", "origin": "sftp"})
+	if err != nil {
+		t.Fatalf("ParseTagTemplates() => %s", err)
+	}
+	rec := &recordDest{}
+	p.user.Storage = event.Storage{Class: "DEEP_ARCHIVE", Tags: tags, Metadata: true}
+	p.user.Target = event.Target{Destinations: []event.Destination{rec}}
+
+	p.watch("export (1).txt")
+	t.Run("Test object carries storage settings", func(t *testing.T) {
+		if len(rec.objs) != 1 {
+			t.Fatalf("Put() called %d times, want 1", len(rec.objs))
+		}
+		obj := rec.objs[0]
+		if obj.StorageClass != "DEEP_ARCHIVE" {
+			t.Errorf("StorageClass => %q, want DEEP_ARCHIVE", obj.StorageClass)
+		}
+		exp := map[string]string{"tenant": "user1", "file": "export _1_.txt", "origin": "sftp"}
+		for k, v := range exp {
+			if obj.Tags[k] != v {
+				t.Errorf("Tags[%s] => %q, want %q", k, obj.Tags[k], v)
+			}
+		}
+		if obj.Metadata["Source-Size"] != "350" || obj.Metadata["Source-Mode"] != "0644" {
+			t.Errorf("Metadata => %v, want source size 350 and mode 0644", obj.Metadata)
+		}
+		if obj.Metadata["Source-Path"] != filepath.Join(p.src, "export (1).txt") {
+			t.Errorf("Metadata[Source-Path] => %q, want original path", obj.Metadata["Source-Path"])
+		}
+	})
+}
+
+// Ensure that tags are rendered from the source file, not from the prefixed, templated and
+// compressed object key
+func Test_StorageTagsSource(t *testing.T) {
+	p := newTestPipeline(t)
+	p.write("export.csv", []byte(strings.Repeat("id,value\n1,2\n", 10)))
+	tags, err := event.ParseTagTemplates(map[string]string{"file": "{{.Name}}", "dir": "{{.Dir}}"})
+	if err != nil {
+		t.Fatalf("ParseTagTemplates() => %s", err)
+	}
+	key, err := event.ParseKeyTemplate("{{.User}}/{{.Hash}}")
+	if err != nil {
+		t.Fatalf("ParseKeyTemplate() => %s", err)
+	}
+	rec := &recordDest{}
+	p.user.Key = key
+	p.user.Output = event.Output{Compression: "gzip"}
+	p.user.Storage = event.Storage{Tags: tags}
+	p.user.Target = event.Target{Destinations: []event.Destination{rec}, Prefix: "tenantA"}
+
+	p.watch("export.csv")
+	t.Run("Test tags of the original file name", func(t *testing.T) {
+		if len(rec.objs) != 1 {
+			t.Fatalf("Put() called %d times, want 1", len(rec.objs))
+		}
+		obj := rec.objs[0]
+		if !strings.HasPrefix(obj.Key, "tenantA/user1/") || !strings.HasSuffix(obj.Key, ".gz") {
+			t.Errorf("Key => %q, want tenantA/user1/<hash>.gz", obj.Key)
+		}
+		exp := map[string]string{"file": "export.csv", "dir": "data"}
+		for k, v := range exp {
+			if obj.Tags[k] != v {
+				t.Errorf("Tags[%s] => %q, want %q", k, obj.Tags[k], v)
+			}
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package sftppush
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// trickleDest is a Destination reading chunk bytes of the body at every tick, it never reads
+// anything with a zero chunk
+type trickleDest struct {
+	chunk int
+	every time.Duration
+	puts  int32
+}
+
+func (d *trickleDest) String() string { return "trickle://" }
+
+func (d *trickleDest) Put(ctx context.Context, obj *event.Object) (*event.PutOutput, error) {
+	atomic.AddInt32(&d.puts, 1)
+	buf := make([]byte, d.chunk)
+	for {
+		if d.chunk > 0 {
+			if _, err := obj.Body.Read(buf); err == io.EOF {
+				return &event.PutOutput{Location: obj.Key}, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(d.every):
+		}
+	}
+}
+
+// Ensure that uploads exceeding their size dependent timeout are reported as canceled, uploads
+// not moving within the idle timeout as stalled, and that both are retried and not removed
+func Test_Timeout(t *testing.T) {
+	var Results = []struct {
+		timeout event.Timeout
+		chunk   int
+		status  string // empty if uploaded
+	}{
+		{event.Timeout{Base: 5 * time.Second, Idle: 200 * time.Millisecond}, 50, ""},
+		{event.Timeout{PerMB: 300 * time.Millisecond, Idle: 200 * time.Millisecond}, 1, "canceled"},
+		{event.Timeout{Base: 5 * time.Second, Idle: 200 * time.Millisecond}, 0, "stalled"},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		p.write("plain.txt", []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+		dst := &trickleDest{chunk: rr.chunk, every: 10 * time.Millisecond}
+		p.user.Target = event.Target{Destinations: []event.Destination{dst}}
+		p.user.Timeout = rr.timeout
+		p.epi.Retry = event.Retry{Attempts: 2}
+		hook := test.NewLocal(p.lg)
+
+		p.watch("plain.txt")
+		t.Run("Test upload timeouts "+rr.status, func(t *testing.T) {
+			statuses := make([]string, 0)
+			for _, e := range hook.AllEntries() {
+				if e.Level == logrus.WarnLevel && strings.HasPrefix(e.Message, "Results:") {
+					statuses = append(statuses, strings.Fields(e.Message)[1])
+				}
+			}
+			_, err := p.deadLettered("plain.txt")
+			if rr.status == "" {
+				if len(statuses) > 0 || err == nil || dst.puts != 1 {
+					t.Errorf("NewWatcher() => %v, %d Puts, want uploaded once", statuses, dst.puts)
+				}
+				return
+			}
+			if len(statuses) != 2 || statuses[0] != rr.status || statuses[1] != rr.status {
+				t.Errorf("NewWatcher() results => %v, want %s twice", statuses, rr.status)
+			}
+			if err != nil || dst.puts != 2 {
+				t.Errorf("NewWatcher() dead-lettered => %v after %d Puts, want dead-lettered after 2", err, dst.puts)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package sftppush
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// Ensure that files of a denied type are moved to quarantine instead of being pushed
+func Test_Quarantine(t *testing.T) {
+	p := newTestPipeline(t)
+	p.write("plain.txt", []byte("plain text with more than 32 bytes"))
+	p.user.Quarantine = p.qt
+	p.user.Types = event.Types{Allow: []string{"application/*"}, Deny: []string{"text/*"}}
+	p.epi.Retry = event.Retry{Attempts: 3}
+
+	p.watch("plain.txt")
+	t.Run("Test denied type is quarantined without retry", func(t *testing.T) {
+		act, err := p.quarantined("plain.txt")
+		if err != nil {
+			t.Fatalf("NewWatcher() quarantine sidecar => %s, want quarantined", err)
+		}
+		if !strings.Contains(act.Error, "text/plain rejected") {
+			t.Errorf("NewWatcher() sidecar error => %q, want text/plain rejected", act.Error)
+		}
+		if _, err := os.Stat(filepath.Join(p.qt, "data", "plain.txt")); err != nil {
+			t.Errorf("NewWatcher() quarantined file => %s", err)
+		}
+		if _, err := p.deadLettered("plain.txt"); err == nil {
+			t.Errorf("NewWatcher() dead-lettered plain.txt, want quarantined only")
+		}
+	})
+}
+
+// Ensure that files shorter than the sniffed prefix are pushed as they are, whether found by
+// the backfill or closed while watching
+func Test_SmallFile(t *testing.T) {
+	p := newTestPipeline(t)
+	p.write("small.json", []byte(`{"a":1}`))
+	p.user.Target = event.Target{Destinations: []event.Destination{event.NewDirDestination(p.out)}}
+
+	stop := p.start()
+	p.write("small.csv", []byte("a,b\n1,2\n"))
+	p.waitGone("small.json", "small.csv")
+	stop()
+	t.Run("Test files under 32 bytes are pushed", func(t *testing.T) {
+		for n, exp := range map[string]string{"small.json": `{"a":1}`, "small.csv": "a,b\n1,2\n"} {
+			act, err := ioutil.ReadFile(filepath.Join(p.out, "user1", "data", n))
+			if err != nil {
+				t.Fatalf("ReadFile() => %s, want %s pushed", err, n)
+			}
+			if string(act) != exp {
+				t.Errorf("pushed %s => %q, want %q", n, act, exp)
+			}
+		}
+	})
+}
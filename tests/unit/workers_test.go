@@ -0,0 +1,91 @@
+package sftppush
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olmax99/sftppush/pkg/event"
+)
+
+// slowDest is a Destination taking its time for every object, it records the keys in order of
+// arrival and the highest number of concurrent uploads
+type slowDest struct {
+	sync.Mutex
+	delay  time.Duration
+	active int
+	max    int
+	keys   []string
+}
+
+func (d *slowDest) String() string { return "slow://" }
+
+func (d *slowDest) Put(ctx context.Context, obj *event.Object) (*event.PutOutput, error) {
+	d.Lock()
+	d.keys = append(d.keys, obj.Key)
+	d.active++
+	if d.active > d.max {
+		d.max = d.active
+	}
+	d.Unlock()
+	defer func() {
+		d.Lock()
+		d.active--
+		d.Unlock()
+	}()
+	if _, err := io.Copy(ioutil.Discard, obj.Body); err != nil {
+		return nil, err
+	}
+	time.Sleep(d.delay)
+	return &event.PutOutput{Location: obj.Key}, nil
+}
+
+// Ensure that several workers push files concurrently, and that with Ordered the files of a
+// source directory are pushed one after the other in order of arrival
+func Test_Workers(t *testing.T) {
+	var Results = []struct {
+		ordered    bool
+		concurrent bool
+	}{
+		{false, true},
+		{true, false},
+	}
+	for _, rr := range Results {
+		p := newTestPipeline(t)
+		names := make([]string, 6)
+		for i := range names {
+			// backfill walks the source directory in lexical order
+			names[i] = fmt.Sprintf("plain%d.txt", i)
+			p.write(names[i], []byte(strings.Repeat("plain text with more than 32 bytes\n", 10)))
+		}
+		dst := &slowDest{delay: 100 * time.Millisecond}
+		p.user.Target = event.Target{Destinations: []event.Destination{dst}}
+		p.epi.Workers = 4
+		p.epi.Ordered = rr.ordered
+
+		p.watch(names...)
+		t.Run(fmt.Sprintf("Test workers ordered %t", rr.ordered), func(t *testing.T) {
+			dst.Lock()
+			defer dst.Unlock()
+			if concurrent := dst.max > 1; concurrent != rr.concurrent {
+				t.Errorf("NewWatcher() pushed %d objects at once, want concurrent %t", dst.max, rr.concurrent)
+			}
+			if len(dst.keys) != len(names) {
+				t.Fatalf("NewWatcher() pushed %d objects, want %d", len(dst.keys), len(names))
+			}
+			if !rr.ordered {
+				return
+			}
+			for i, n := range names {
+				if exp := "user1/data/" + n; dst.keys[i] != exp {
+					t.Errorf("NewWatcher() pushed #%d %s, want %s", i+1, dst.keys[i], exp)
+				}
+			}
+		})
+	}
+}